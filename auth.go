@@ -0,0 +1,388 @@
+package nic
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Authenticator is the interface implemented by H.Auth
+//
+//	nic.H{Auth: nic.BearerAuth("token")}
+//	nic.H{Auth: nic.KV{"user": "passwd"}}
+type Authenticator interface {
+	Authenticate(*http.Request) error
+}
+
+// challenger is implemented by authenticators which need the server's
+// 401 response, e.g. digest, true means the request should be sent again
+type challenger interface {
+	challenge(*http.Response) bool
+}
+
+// Authenticate implements Authenticator, KV{"user": "passwd"} is basic auth
+func (kv KV) Authenticate(req *http.Request) error {
+	if len(kv) != 1 {
+		return fmt.Errorf("nic: basic-auth KV must contain exactly one entry, got %d", len(kv))
+	}
+	for k, v := range kv {
+		vs, ok := v.(string)
+		if !ok {
+			return fmt.Errorf(
+				"nic: basic-auth %v[%T] must be string type",
+				v, v)
+		}
+		req.SetBasicAuth(k, vs)
+	}
+	return nil
+}
+
+// AuthFunc is an adapter to use ordinary functions as Authenticator
+type AuthFunc func(*http.Request) error
+
+// Authenticate implements Authenticator
+func (f AuthFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+// BasicAuth returns a HTTP basic authenticator
+func BasicAuth(username, password string) Authenticator {
+	return basicAuth{username, password}
+}
+
+func (a basicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+type bearerAuth string
+
+// BearerAuth returns a `Authorization: Bearer <token>` authenticator
+func BearerAuth(token string) Authenticator {
+	return bearerAuth(token)
+}
+
+func (a bearerAuth) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(a))
+	return nil
+}
+
+type apiKeyAuth struct {
+	name  string
+	key   string
+	query bool
+}
+
+// APIKeyHeader returns an authenticator setting the key in header `name`
+func APIKeyHeader(name, key string) Authenticator {
+	return apiKeyAuth{name: name, key: key}
+}
+
+// APIKeyQuery returns an authenticator setting the key in query param `name`
+func APIKeyQuery(name, key string) Authenticator {
+	return apiKeyAuth{name: name, key: key, query: true}
+}
+
+func (a apiKeyAuth) Authenticate(req *http.Request) error {
+	if a.query {
+		q := req.URL.Query()
+		q.Set(a.name, a.key)
+		req.URL.RawQuery = q.Encode()
+	} else {
+		req.Header.Set(a.name, a.key)
+	}
+	return nil
+}
+
+// digestAuth implements RFC 7616, the challenge is cached
+// so the following requests are authenticated directly
+type digestAuth struct {
+	username string
+	password string
+
+	params map[string]string
+	nc     int
+	sync.Mutex
+}
+
+// DigestAuth returns a HTTP digest authenticator
+func DigestAuth(username, password string) Authenticator {
+	return &digestAuth{
+		username: username,
+		password: password,
+	}
+}
+
+func (a *digestAuth) challenge(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	for _, h := range resp.Header.Values("WWW-Authenticate") {
+		if len(h) > 7 && strings.EqualFold(h[:7], "digest ") {
+			a.Lock()
+			a.params = parseAuthParams(h[7:])
+			a.nc = 0
+			a.Unlock()
+			return true
+		}
+	}
+	return false
+}
+
+func (a *digestAuth) Authenticate(req *http.Request) error {
+	a.Lock()
+	defer a.Unlock()
+	if a.params == nil {
+		// wait for the challenge
+		return nil
+	}
+
+	var h func() hash.Hash
+	algorithm := a.params["algorithm"]
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		h = md5.New
+	case "SHA-256":
+		h = sha256.New
+	default:
+		return fmt.Errorf("nic: unsupported digest algorithm %s", algorithm)
+	}
+	digest := func(s string) string {
+		sum := h()
+		io.WriteString(sum, s)
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	realm, nonce := a.params["realm"], a.params["nonce"]
+	uri := req.URL.RequestURI()
+	ha1 := digest(a.username + ":" + realm + ":" + a.password)
+	ha2 := digest(req.Method + ":" + uri)
+
+	v := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`,
+		escapeQuotes(a.username), escapeQuotes(realm), escapeQuotes(nonce), escapeQuotes(uri))
+	if algorithm != "" {
+		v += ", algorithm=" + algorithm
+	}
+
+	qop := ""
+	for _, q := range strings.Split(a.params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		a.nc++
+		nc := fmt.Sprintf("%08x", a.nc)
+		b := make([]byte, 8)
+		rand.Read(b)
+		cnonce := fmt.Sprintf("%x", b)
+		response := digest(strings.Join([]string{ha1, nonce, nc, cnonce, qop, ha2}, ":"))
+		v += fmt.Sprintf(`, response="%s", qop=%s, nc=%s, cnonce="%s"`, response, qop, nc, cnonce)
+	} else {
+		v += fmt.Sprintf(`, response="%s"`, digest(ha1+":"+nonce+":"+ha2))
+	}
+	if opaque, ok := a.params["opaque"]; ok {
+		v += fmt.Sprintf(`, opaque="%s"`, escapeQuotes(opaque))
+	}
+
+	req.Header.Set("Authorization", v)
+	return nil
+}
+
+// parseAuthParams parses `realm="a", nonce="b", qop="auth,auth-int"`
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		k := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " ")
+
+		v := ""
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(s) {
+				i = len(s) - 1
+			}
+			v = strings.Replace(s[1:i], `\`, "", -1)
+			s = s[i+1:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			v = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[k] = v
+	}
+	return params
+}
+
+// matchHost reports whether host matches any of patterns,
+// `example.com` matches the host itself, `*.example.com` matches subdomains,
+// a pattern without port matches any port
+func matchHost(patterns []string, host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	hostname = strings.ToLower(hostname)
+	host = strings.ToLower(host)
+
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		target := hostname
+		if _, _, err := net.SplitHostPort(p); err == nil {
+			target = host
+		}
+		if strings.HasPrefix(p, "*.") {
+			if strings.HasSuffix(target, p[1:]) {
+				return true
+			}
+		} else if target == p {
+			return true
+		}
+	}
+	return false
+}
+
+// scopedAuth is an authenticator only applied to matching hosts
+type scopedAuth struct {
+	auth  Authenticator
+	hosts []string
+}
+
+// hostMatcher is implemented by authenticators knowing their own hosts,
+// e.g. netrc
+type hostMatcher interface {
+	matchHost(string) bool
+}
+
+// secrets returns the names of the header fields or query params
+// carrying API keys, which must be redacted when recording
+func (s *scopedAuth) secrets() []string {
+	if s == nil {
+		return nil
+	}
+	if a, ok := s.auth.(apiKeyAuth); ok {
+		return []string{a.name}
+	}
+	return nil
+}
+
+// identity returns a digest of the credentials applied to host,
+// "" means the request is sent anonymously
+func (s *scopedAuth) identity(host string) string {
+	if s == nil || !s.match(host) {
+		return ""
+	}
+	var id string
+	switch a := s.auth.(type) {
+	case basicAuth:
+		id = "basic " + a.username + ":" + a.password
+	case bearerAuth:
+		id = "bearer " + string(a)
+	case apiKeyAuth:
+		id = "apikey " + a.name + ":" + a.key
+	case *digestAuth:
+		// the challenge state changes on every request
+		id = "digest " + a.username + ":" + a.password
+	default:
+		// other authenticators are told apart by instance
+		if v := reflect.ValueOf(a); v.Kind() == reflect.Ptr || v.Kind() == reflect.Func {
+			id = fmt.Sprintf("%T %x", a, v.Pointer())
+		} else {
+			id = fmt.Sprintf("%T %v", a, a)
+		}
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *scopedAuth) match(host string) bool {
+	if m, ok := s.auth.(hostMatcher); ok {
+		return m.matchHost(host)
+	}
+	return matchHost(s.hosts, host)
+}
+
+// authTransport applies authenticator on every hop of a request,
+// redirect targets out of scope never get the credentials
+type authTransport struct {
+	base  http.RoundTripper
+	scope *scopedAuth
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.scope.match(req.URL.Host) {
+		return t.base.RoundTrip(req)
+	}
+
+	r, err := t.authenticate(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := t.scope.auth.(challenger)
+	if !ok || req.GetBody == nil && req.Body != nil && req.Body != http.NoBody || !c.challenge(resp) {
+		return resp, nil
+	}
+
+	// retry once with the challenge
+	r, err = t.authenticate(req)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if req.GetBody != nil {
+		r.Body, err = req.GetBody()
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if ex := exchangeOf(req); ex != nil {
+		ex.resend = true
+	}
+	return t.base.RoundTrip(r)
+}
+
+// authenticate works on a clone, RoundTripper must not modify the request
+func (t *authTransport) authenticate(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	err := t.scope.auth.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,196 @@
+package nic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CookieFormat is the format of cookies exported by browsers
+type CookieFormat int
+
+const (
+	// CookiesTxt is the Netscape `cookies.txt` format
+	CookiesTxt CookieFormat = iota
+	// EditThisCookie is the JSON format of the EditThisCookie extension
+	EditThisCookie
+)
+
+// editThisCookie is an element of the EditThisCookie JSON array
+type editThisCookie struct {
+	Domain         string  `json:"domain"`
+	ExpirationDate float64 `json:"expirationDate,omitempty"`
+	HostOnly       bool    `json:"hostOnly"`
+	HttpOnly       bool    `json:"httpOnly"`
+	Name           string  `json:"name"`
+	Path           string  `json:"path"`
+	SameSite       string  `json:"sameSite"`
+	Secure         bool    `json:"secure"`
+	Session        bool    `json:"session"`
+	StoreID        string  `json:"storeId"`
+	Value          string  `json:"value"`
+	ID             int     `json:"id"`
+}
+
+var sameSiteNames = map[string]http.SameSite{
+	"no_restriction": http.SameSiteNoneMode,
+	"lax":            http.SameSiteLaxMode,
+	"strict":         http.SameSiteStrictMode,
+}
+
+func sameSiteName(mode http.SameSite) string {
+	for name, m := range sameSiteNames {
+		if m == mode {
+			return name
+		}
+	}
+	return "unspecified"
+}
+
+func parseEditThisCookie(r io.Reader) ([]*jarEntry, error) {
+	cookies := make([]editThisCookie, 0)
+	err := json.NewDecoder(r).Decode(&cookies)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*jarEntry, 0, len(cookies))
+	for _, c := range cookies {
+		e := &jarEntry{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   strings.ToLower(strings.TrimPrefix(c.Domain, ".")),
+			Path:     c.Path,
+			HostOnly: c.HostOnly,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			SameSite: sameSiteNames[c.SameSite],
+		}
+		if !c.Session && c.ExpirationDate != 0 {
+			sec, frac := math.Modf(c.ExpirationDate)
+			e.Expires = time.Unix(int64(sec), int64(frac*1e9))
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ImportCookies seeds the session with cookies exported from a browser,
+// every cookie is set as if by a response of its own domain,
+// it returns how many cookies are rejected, e.g. the domain cookies
+// of a public suffix (`.com`)
+func (s *Session) ImportCookies(r io.Reader, format CookieFormat) (skipped int, err error) {
+	var entries []*jarEntry
+	switch format {
+	case CookiesTxt:
+		entries, err = parseNetscape(r)
+	case EditThisCookie:
+		entries, err = parseEditThisCookie(r)
+	default:
+		return 0, fmt.Errorf("nic: unknown cookie format %d", format)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	jar, err := s.jar()
+	if err != nil {
+		return 0, err
+	}
+
+	jar.Lock()
+	defer jar.Unlock()
+	now := time.Now()
+	for _, e := range entries {
+		// the domain of the URL below is the host, so the jar would keep
+		// a public suffix domain cookie as a host-only cookie,
+		// host-only cookies of e.g. localhost are fine
+		if !e.HostOnly && jar.PublicSuffixList != nil &&
+			jar.PublicSuffixList.PublicSuffix(e.Domain) == e.Domain {
+			skipped++
+			continue
+		}
+		u := &url.URL{
+			Scheme: "http",
+			Host:   e.Domain,
+			Path:   e.Path,
+		}
+		if e.Secure {
+			u.Scheme = "https"
+		}
+
+		c := &http.Cookie{
+			Name:     e.Name,
+			Value:    e.Value,
+			Path:     e.Path,
+			Expires:  e.Expires,
+			Secure:   e.Secure,
+			HttpOnly: e.HttpOnly,
+			SameSite: e.SameSite,
+		}
+		if !e.HostOnly {
+			c.Domain = e.Domain
+		}
+		imported := jar.newEntry(u, c, now)
+		if imported == nil {
+			skipped++
+			continue
+		}
+		jar.store(imported, now)
+	}
+	return skipped, nil
+}
+
+// ExportCookies writes the session's cookies in a format
+// which could be imported by browsers
+func (s *Session) ExportCookies(w io.Writer, format CookieFormat) error {
+	s.Lock()
+	jar, err := s.jar()
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case CookiesTxt:
+		return jar.WriteNetscape(w)
+	case EditThisCookie:
+	default:
+		return fmt.Errorf("nic: unknown cookie format %d", format)
+	}
+
+	cookies := make([]editThisCookie, 0)
+	for i, e := range jar.all() {
+		c := editThisCookie{
+			Domain:   e.Domain,
+			HostOnly: e.HostOnly,
+			HttpOnly: e.HttpOnly,
+			Name:     e.Name,
+			Path:     e.Path,
+			SameSite: sameSiteName(e.SameSite),
+			Secure:   e.Secure,
+			Session:  e.Expires.IsZero(),
+			StoreID:  "0",
+			Value:    e.Value,
+			ID:       i + 1,
+		}
+		if !e.HostOnly {
+			c.Domain = "." + e.Domain
+		}
+		if !c.Session {
+			c.ExpirationDate = float64(e.Expires.UnixNano()) / 1e9
+		}
+		cookies = append(cookies, c)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(cookies)
+}
@@ -0,0 +1,503 @@
+package nic
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStatus tells how a Response is got when the session cache is enabled
+type CacheStatus int
+
+const (
+	// CacheNone means the cache is not used
+	CacheNone CacheStatus = iota
+	// CacheFetched means the response is fetched from the server
+	CacheFetched
+	// CacheHit means the response is served from cache without network
+	CacheHit
+	// CacheRevalidated means the cached response is confirmed by a 304 response
+	CacheRevalidated
+	// CacheMiss means an only-if-cached request has no stored response,
+	// the 504 response is made up without network
+	CacheMiss
+)
+
+func (c CacheStatus) String() string {
+	switch c {
+	case CacheFetched:
+		return "fetched"
+	case CacheHit:
+		return "hit"
+	case CacheRevalidated:
+		return "revalidated"
+	case CacheMiss:
+		return "miss"
+	}
+	return "none"
+}
+
+// CacheStorage is the interface of the session cache's backend
+type CacheStorage interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+type lruItem struct {
+	key   string
+	value []byte
+}
+
+// memoryCache is a LRU CacheStorage
+type memoryCache struct {
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+	sync.Mutex
+}
+
+// NewMemoryCache returns an in-memory LRU storage,
+// maxEntries <= 0 means no limit
+func NewMemoryCache(maxEntries int) CacheStorage {
+	return &memoryCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*lruItem).value, true
+	}
+	return nil, false
+}
+
+func (c *memoryCache) Set(key string, value []byte) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*lruItem).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruItem{key, value})
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem).key)
+	}
+}
+
+func (c *memoryCache) Delete(key string) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// diskCache stores every entry in a file named by the key's sha256
+type diskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a storage in the directory, it's created if not exist
+func NewDiskCache(dir string) (CacheStorage, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &diskCache{dir}, nil
+}
+
+func (c *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *diskCache) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *diskCache) Set(key string, value []byte) {
+	tmp, err := ioutil.TempFile(c.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(value)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if os.Rename(tmp.Name(), c.path(key)) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+func (c *diskCache) Delete(key string) {
+	os.Remove(c.path(key))
+}
+
+// cacheEntry is a stored response
+type cacheEntry struct {
+	RequestTime  time.Time
+	ResponseTime time.Time
+	Vary         map[string]string
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+}
+
+// cacheKey tells apart the responses of different credentials,
+// as they are applied after the cache lookup
+func cacheKey(method string, req *http.Request, auth *scopedAuth) string {
+	key := method + " " + req.URL.String()
+	if id := auth.identity(req.URL.Host); id != "" {
+		key += " " + id
+	}
+	return key
+}
+
+func loadCacheEntry(storage CacheStorage, key string, req *http.Request) *cacheEntry {
+	data, ok := storage.Get(key)
+	if !ok {
+		return nil
+	}
+	e := &cacheEntry{}
+	if json.Unmarshal(data, e) != nil {
+		return nil
+	}
+
+	// the selecting header fields must match
+	for k, v := range e.Vary {
+		if req.Header.Get(k) != v {
+			return nil
+		}
+	}
+	return e
+}
+
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	return storedResponse(req, e.StatusCode, e.Header, e.Body)
+}
+
+// storedResponse builds a response which is not from the network
+func storedResponse(req *http.Request, code int, header http.Header, body []byte) *http.Response {
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	if req.Method == HEAD {
+		resp.Body = http.NoBody
+	}
+	return resp
+}
+
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			kv := strings.SplitN(directive, "=", 2)
+			k := strings.ToLower(strings.TrimSpace(kv[0]))
+			if len(kv) == 2 {
+				cc[k] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			} else {
+				cc[k] = ""
+			}
+		}
+	}
+	return cc
+}
+
+func parseSeconds(v string) (time.Duration, bool) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+func (e *cacheEntry) date() time.Time {
+	if t, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return t
+	}
+	return e.ResponseTime
+}
+
+// freshness returns the freshness lifetime, RFC 9111 section 4.2.1
+func (e *cacheEntry) freshness() time.Duration {
+	if d, ok := parseSeconds(parseCacheControl(e.Header)["max-age"]); ok {
+		return d
+	}
+	if exp := e.Header.Get("Expires"); exp != "" {
+		t, err := http.ParseTime(exp)
+		if err != nil {
+			// invalid date means already expired
+			return 0
+		}
+		return t.Sub(e.date())
+	}
+	// heuristic freshness, 10% of the time since last modification
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil {
+		return e.date().Sub(lm) / 10
+	}
+	return 0
+}
+
+// age returns the current age, RFC 9111 section 4.2.3
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	apparent := e.ResponseTime.Sub(e.date())
+	if apparent < 0 {
+		apparent = 0
+	}
+	ageValue, _ := parseSeconds(e.Header.Get("Age"))
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if corrected > apparent {
+		apparent = corrected
+	}
+	return apparent + now.Sub(e.ResponseTime)
+}
+
+// fresh reports whether e could be served without revalidation
+func (e *cacheEntry) fresh(req *http.Request, now time.Time) bool {
+	if _, ok := parseCacheControl(e.Header)["no-cache"]; ok {
+		return false
+	}
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-cache"]; ok || req.Header.Get("Pragma") == "no-cache" {
+		return false
+	}
+
+	age := e.age(now)
+	if maxAge, ok := parseSeconds(reqCC["max-age"]); ok && age > maxAge {
+		return false
+	}
+	return age < e.freshness()
+}
+
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// cacheable reports whether resp could be stored, RFC 9111 section 3
+func cacheable(req *http.Request, resp *http.Response) bool {
+	if req.Method != GET || !cacheableStatus[resp.StatusCode] {
+		return false
+	}
+	if _, ok := parseCacheControl(req.Header)["no-store"]; ok {
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	_, maxAge := cc["max-age"]
+	_, noCache := cc["no-cache"]
+	return maxAge || noCache ||
+		resp.Header.Get("Expires") != "" ||
+		resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != ""
+}
+
+// cacheTransport is a private cache of RFC 9111
+type cacheTransport struct {
+	base    http.RoundTripper
+	storage CacheStorage
+	auth    *scopedAuth
+
+	// serve stored responses even if they are stale
+	offline bool
+}
+
+func setCacheStatus(req *http.Request, status CacheStatus) {
+	if ex := exchangeOf(req); ex != nil {
+		ex.cache = status
+	}
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != GET && req.Method != HEAD {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil && resp.StatusCode < 400 {
+			// unsafe methods invalidate the stored response
+			t.storage.Delete(cacheKey(GET, req, t.auth))
+		}
+		setCacheStatus(req, CacheFetched)
+		return resp, err
+	}
+
+	// a HEAD request could be answered by the stored GET response
+	lookup := req
+	if req.Method == HEAD {
+		lookup = req.Clone(req.Context())
+		lookup.Method = GET
+	}
+
+	now := time.Now()
+	e := loadCacheEntry(t.storage, cacheKey(GET, lookup, t.auth), lookup)
+	if e != nil && (t.offline || e.fresh(req, now)) {
+		setCacheStatus(req, CacheHit)
+		return e.response(req), nil
+	}
+
+	if _, ok := parseCacheControl(req.Header)["only-if-cached"]; ok {
+		setCacheStatus(req, CacheMiss)
+		return (&cacheEntry{StatusCode: http.StatusGatewayTimeout, Header: http.Header{}}).response(req), nil
+	}
+
+	r := req
+	etag, lastModified := "", ""
+	if e != nil {
+		etag, lastModified = e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+	}
+	if etag != "" || lastModified != "" {
+		r = req.Clone(req.Context())
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			r.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := time.Now()
+
+	if resp.StatusCode == http.StatusNotModified && r != req {
+		resp.Body.Close()
+		// update the stored header fields, RFC 9111 section 4.3.4
+		for k, v := range resp.Header {
+			if k != "Content-Length" {
+				e.Header[k] = v
+			}
+		}
+		e.RequestTime, e.ResponseTime = now, responseTime
+		t.store(lookup, e)
+		setCacheStatus(req, CacheRevalidated)
+		return e.response(req), nil
+	}
+
+	setCacheStatus(req, CacheFetched)
+	if !cacheable(req, resp) {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	e = &cacheEntry{
+		RequestTime:  now,
+		ResponseTime: responseTime,
+		Vary:         make(map[string]string),
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         body,
+	}
+	for _, v := range resp.Header.Values("Vary") {
+		for _, k := range strings.Split(v, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if k != "" {
+				e.Vary[k] = req.Header.Get(k)
+			}
+		}
+	}
+	t.store(req, e)
+	return resp, nil
+}
+
+func (t *cacheTransport) store(req *http.Request, e *cacheEntry) {
+	data, err := json.Marshal(e)
+	if err == nil {
+		t.storage.Set(cacheKey(req.Method, req, t.auth), data)
+	}
+}
+
+// SetCache enables the HTTP cache of RFC 9111 for GET requests,
+// pass nil to disable it
+//
+//	session.SetCache(nic.NewMemoryCache(128))
+func (s *Session) SetCache(storage CacheStorage) {
+	s.Lock()
+	defer s.Unlock()
+	s.cache = storage
+}
+
+// ErrOfflineMiss will be throwed in offline mode
+// when there is no stored response for the request
+type ErrOfflineMiss struct {
+	Method string
+	URL    string
+}
+
+func (e *ErrOfflineMiss) Error() string {
+	return fmt.Sprintf("nic: Offline mode has no response for %s %s", e.Method, e.URL)
+}
+
+// offlineTransport never touches the network
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, &ErrOfflineMiss{
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+}
+
+// SetOffline switches the offline mode, only the stored responses
+// are served and the network is never used, a miss returns an error
+// wrapping *ErrOfflineMiss, check it with errors.As
+func (s *Session) SetOffline(offline bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.offline = offline
+}
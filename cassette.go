@@ -0,0 +1,338 @@
+package nic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// CassetteMode controls whether a cassette records or replays
+type CassetteMode int
+
+const (
+	// CassetteAuto replays if the cassette file exists, otherwise records
+	CassetteAuto CassetteMode = iota
+	// CassetteRecord always sends requests and records them
+	CassetteRecord
+	// CassetteReplay never touches the network, a miss returns an error
+	// wrapping *ErrOfflineMiss
+	CassetteReplay
+)
+
+const redacted = "[REDACTED]"
+
+// header fields redacted by HAR recorders by default
+var recordRedacted = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// header fields redacted by cassettes by default, cookies are kept
+// so that a recorded login still works when replaying
+var cassetteRedacted = []string{"Authorization", "Proxy-Authorization"}
+
+// CassetteBody is a recorded body, binary data is base64 encoded
+type CassetteBody struct {
+	Encoding string `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+	Data     string `json:"data" yaml:"data"`
+}
+
+func newCassetteBody(data []byte) CassetteBody {
+	if utf8.Valid(data) {
+		return CassetteBody{Data: string(data)}
+	}
+	return CassetteBody{
+		Encoding: "base64",
+		Data:     base64.StdEncoding.EncodeToString(data),
+	}
+}
+
+// Bytes returns the decoded body
+func (b CassetteBody) Bytes() []byte {
+	if b.Encoding == "base64" {
+		data, _ := base64.StdEncoding.DecodeString(b.Data)
+		return data
+	}
+	return []byte(b.Data)
+}
+
+// CassetteRequest is a recorded request
+type CassetteRequest struct {
+	Method  string       `json:"method" yaml:"method"`
+	URL     string       `json:"url" yaml:"url"`
+	Headers http.Header  `json:"headers" yaml:"headers"`
+	Body    CassetteBody `json:"body" yaml:"body"`
+}
+
+// CassetteResponse is a recorded response
+type CassetteResponse struct {
+	StatusCode int          `json:"status" yaml:"status"`
+	Headers    http.Header  `json:"headers" yaml:"headers"`
+	Body       CassetteBody `json:"body" yaml:"body"`
+}
+
+// Interaction is a recorded request/response pair
+type Interaction struct {
+	Request  CassetteRequest  `json:"request" yaml:"request"`
+	Response CassetteResponse `json:"response" yaml:"response"`
+
+	used bool
+}
+
+// Matcher reports whether the recorded interaction matches the request,
+// body is the request's body
+type Matcher func(req *http.Request, body []byte, i *Interaction) bool
+
+var (
+	// MatchMethod compares the request method
+	MatchMethod Matcher = func(req *http.Request, body []byte, i *Interaction) bool {
+		return req.Method == i.Request.Method
+	}
+
+	// MatchURL compares the full URL
+	MatchURL Matcher = func(req *http.Request, body []byte, i *Interaction) bool {
+		return req.URL.String() == i.Request.URL
+	}
+
+	// MatchBody compares the body, the random boundaries
+	// of multipart bodies are ignored
+	MatchBody Matcher = func(req *http.Request, body []byte, i *Interaction) bool {
+		recorded := i.Request.Body.Bytes()
+		_, p1, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		_, p2, _ := mime.ParseMediaType(i.Request.Headers.Get("Content-Type"))
+		if p1["boundary"] != "" && p2["boundary"] != "" {
+			body = bytes.Replace(body, []byte(p1["boundary"]), nil, -1)
+			recorded = bytes.Replace(recorded, []byte(p2["boundary"]), nil, -1)
+		}
+		return bytes.Equal(body, recorded)
+	}
+)
+
+// MatchHeaders compares the given header fields,
+// redacted ones, e.g. Authorization, never match as the values are not recorded
+func MatchHeaders(names ...string) Matcher {
+	return func(req *http.Request, body []byte, i *Interaction) bool {
+		for _, name := range names {
+			if strings.Join(req.Header.Values(name), ",") != strings.Join(i.Request.Headers.Values(name), ",") {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Cassette records real request/response pairs into a YAML or JSON file
+// then replays them, VCR-style
+//
+//	cassette, err := nic.LoadCassette("testdata/api.yaml")
+//	session.UseCassette(cassette.Redact("X-Api-Key"))
+//	// ......
+//	err = cassette.Save()
+type Cassette struct {
+	Path         string
+	Mode         CassetteMode
+	Interactions []*Interaction
+
+	matchers []Matcher
+	redact   []string
+	sync.Mutex
+}
+
+// LoadCassette reads the cassette file if exists, `.yaml` and `.yml` files
+// are YAML, others are JSON, the default matchers are method and URL,
+// Authorization and Proxy-Authorization headers and the API keys
+// of APIKeyHeader and APIKeyQuery are redacted
+func LoadCassette(path string) (*Cassette, error) {
+	c := &Cassette{
+		Path:         path,
+		Interactions: make([]*Interaction, 0),
+		matchers:     []Matcher{MatchMethod, MatchURL},
+		redact:       append([]string{}, cassetteRedacted...),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if isYAMLFile(path) {
+		err = yaml.Unmarshal(data, &c.Interactions)
+	} else {
+		err = json.Unmarshal(data, &c.Interactions)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Mode == CassetteAuto {
+		c.Mode = CassetteReplay
+	}
+	return c, nil
+}
+
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// MatchOn changes the matching rules
+// invoke it in a chain
+func (c *Cassette) MatchOn(matchers ...Matcher) *Cassette {
+	c.matchers = matchers
+	return c
+}
+
+// Redact adds header fields and query params
+// which are replaced with `[REDACTED]` when saving,
+// e.g. Redact("Cookie", "Set-Cookie") if replays don't need the session
+// invoke it in a chain
+func (c *Cassette) Redact(names ...string) *Cassette {
+	c.redact = append(c.redact, names...)
+	return c
+}
+
+func (c *Cassette) redactHeader(h http.Header, secrets []string) http.Header {
+	h = h.Clone()
+	for _, name := range append(secrets, c.redact...) {
+		if _, ok := h[http.CanonicalHeaderKey(name)]; ok {
+			h.Set(name, redacted)
+		}
+	}
+	return h
+}
+
+// redactURL redacts the query params, the URL is matched redacted too
+func (c *Cassette) redactURL(u *url.URL, secrets []string) *url.URL {
+	if u.RawQuery == "" {
+		return u
+	}
+	r := *u
+	r.RawQuery = DumpOptions{Redact: append(secrets, c.redact...)}.redactPairs(u.RawQuery)
+	return &r
+}
+
+// Save writes the recorded interactions to Cassette.Path
+func (c *Cassette) Save() error {
+	c.Lock()
+	defer c.Unlock()
+
+	var data []byte
+	var err error
+	if isYAMLFile(c.Path) {
+		data, err = yaml.Marshal(c.Interactions)
+	} else {
+		data, err = json.MarshalIndent(c.Interactions, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.Path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.Path, data, 0644)
+}
+
+// find returns the first unused matching interaction,
+// or the last used one if all are used
+func (c *Cassette) find(req *http.Request, body []byte) *Interaction {
+	c.Lock()
+	defer c.Unlock()
+
+	var found *Interaction
+	for _, i := range c.Interactions {
+		matched := true
+		for _, m := range c.matchers {
+			if !m(req, body, i) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		if !i.used {
+			i.used = true
+			return i
+		}
+		found = i
+	}
+	return found
+}
+
+// cassetteTransport records or replays by the cassette,
+// secrets are the API key names of the session's authenticator
+type cassetteTransport struct {
+	base     http.RoundTripper
+	cassette *Cassette
+	secrets  []string
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := peekRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c := t.cassette
+	if c.Mode == CassetteReplay {
+		matched := *req
+		matched.URL = c.redactURL(req.URL, t.secrets)
+		i := c.find(&matched, body)
+		if i == nil {
+			return nil, &ErrOfflineMiss{
+				Method: req.Method,
+				URL:    req.URL.String(),
+			}
+		}
+		return storedResponse(req, i.Response.StatusCode,
+			i.Response.Headers, i.Response.Body.Bytes()), nil
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	c.Lock()
+	defer c.Unlock()
+	c.Interactions = append(c.Interactions, &Interaction{
+		Request: CassetteRequest{
+			Method:  req.Method,
+			URL:     c.redactURL(req.URL, t.secrets).String(),
+			Headers: c.redactHeader(req.Header, t.secrets),
+			Body:    newCassetteBody(body),
+		},
+		Response: CassetteResponse{
+			StatusCode: resp.StatusCode,
+			Headers:    c.redactHeader(resp.Header, t.secrets),
+			Body:       newCassetteBody(data),
+		},
+	})
+	return resp, nil
+}
+
+// UseCassette records or replays every request by the cassette,
+// pass nil to disable it
+func (s *Session) UseCassette(c *Cassette) {
+	s.Lock()
+	defer s.Unlock()
+	s.cassette = c
+}
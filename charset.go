@@ -0,0 +1,254 @@
+package nic
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+var (
+	// `<meta charset="gbk">` or `<meta http-equiv="Content-Type" content="text/html; charset=gbk">`
+	metaCharsetRegexp = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([\w.:-]+)`)
+
+	// `<?xml version="1.0" encoding="gbk"?>`
+	xmlEncodingRegexp = regexp.MustCompile(`^<\?xml[^>]+encoding\s*=\s*["']([\w.:-]+)["']`)
+
+	// candidates of byte-level sniffing if the body is not utf-8
+	sniffCharsets = []string{"gbk", "big5", "shift_jis", "euc-jp", "euc-kr"}
+)
+
+// sniffLimit bounds the bytes sniffed, so that large bodies aren't decoded many times
+const sniffLimit = 4096
+
+// isTextMediaType reports whether the body of the media type is text,
+// an unknown one, e.g. a missing Content-Type, is taken as text
+func isTextMediaType(mediaType string) bool {
+	if mediaType == "" || strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	for _, suffix := range []string{"+json", "+xml", "+yaml"} {
+		if strings.HasSuffix(mediaType, suffix) {
+			return true
+		}
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/ecmascript", "application/x-javascript",
+		"application/x-www-form-urlencoded", "application/yaml", "application/x-yaml":
+		return true
+	}
+	return false
+}
+
+// charsetName returns the WHATWG name of the label, e.g. "gbk" of "gb2312",
+// or "" if it's not supported
+func charsetName(label string) string {
+	enc, err := htmlindex.Get(strings.TrimSpace(label))
+	if err != nil {
+		return ""
+	}
+	name, err := htmlindex.Name(enc)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// bomCharset returns the charset of the byte order mark and its length
+func bomCharset(data []byte) (string, int) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8", 3
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		return "utf-16be", 2
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		return "utf-16le", 2
+	}
+	return "", 0
+}
+
+// detectCharset detects the charset of a response body, in order of
+// the byte order mark, the Content-Type charset parameter,
+// an HTML `<meta>` tag or an XML declaration in the first 1024 bytes,
+// then byte-level sniffing, it returns "" for binary media types
+func detectCharset(contentType string, data []byte) string {
+	if cs, _ := bomCharset(data); cs != "" {
+		return cs
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		if cs := charsetName(params["charset"]); cs != "" {
+			return cs
+		}
+	}
+	if !isTextMediaType(mediaType) {
+		return ""
+	}
+
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if m := xmlEncodingRegexp.FindSubmatch(bytes.TrimLeft(head, " \t\r\n")); m != nil {
+		if cs := charsetName(string(m[1])); cs != "" {
+			return cs
+		}
+	}
+	if m := metaCharsetRegexp.FindSubmatch(head); m != nil {
+		cs := charsetName(string(m[1]))
+		// a utf-16 body couldn't declare itself in ASCII
+		if cs != "" && !strings.HasPrefix(cs, "utf-16") {
+			return cs
+		}
+	}
+
+	return sniffCharset(data)
+}
+
+// sniffCharset guesses the charset by the fewest invalid characters
+// in the first sniffLimit bytes, windows-1252 is the fallback as browsers do
+func sniffCharset(data []byte) string {
+	truncated := len(data) > sniffLimit
+	if truncated {
+		data = data[:sniffLimit]
+		// don't split the last utf-8 character
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					data = data[:i]
+				}
+				break
+			}
+		}
+	}
+	if utf8.Valid(data) {
+		return "utf-8"
+	}
+
+	best, fewest := "windows-1252", -1
+	for _, cs := range sniffCharsets {
+		text := decodeText(cs, data)
+		if truncated {
+			// the last character may be split
+			text = strings.TrimSuffix(text, string(utf8.RuneError))
+		}
+		invalid := strings.Count(text, string(utf8.RuneError))
+		if fewest < 0 || invalid < fewest {
+			best, fewest = cs, invalid
+		}
+	}
+	if fewest != 0 {
+		return "windows-1252"
+	}
+	return best
+}
+
+// DecodeMode controls how invalid bytes or media types are handled when decoding
+type DecodeMode int
+
+const (
+	// DecodeReplace replaces invalid bytes with U+FFFD
+	DecodeReplace DecodeMode = iota
+	// DecodeStrict fails with ErrInvalidEncoded on invalid bytes,
+	// or on a mismatched Content-Type while decoding into a value
+	DecodeStrict
+)
+
+// decode decodes data in the charset into a utf-8 string,
+// the byte order mark is removed
+func decode(charset string, data []byte, mode DecodeMode) (string, error) {
+	if cs, n := bomCharset(data); cs == charset {
+		data = data[n:]
+	}
+
+	if charset == "utf-8" {
+		if mode == DecodeStrict && !utf8.Valid(data) {
+			return "", ErrInvalidEncoded
+		}
+		return strings.ToValidUTF8(string(data), "\ufffd"), nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil || enc == encoding.Replacement {
+		// labels of the replacement encoding, e.g. "iso-2022-kr", are unsafe to decode
+		return "", ErrUnrecognizedEncoding
+	}
+
+	b, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", err
+	}
+	text := string(b)
+
+	// x/text decoders always replace, so a U+FFFD which is not
+	// encoded in data comes from an invalid sequence
+	if replaced := strings.Count(text, "\ufffd"); mode == DecodeStrict && replaced > 0 {
+		e, err := enc.NewEncoder().Bytes([]byte("\ufffd"))
+		if err != nil || bytes.Count(data, e) < replaced {
+			return "", ErrInvalidEncoded
+		}
+	}
+	return text, nil
+}
+
+// decodeText decodes in the replace mode
+func decodeText(charset string, data []byte) string {
+	text, err := decode(charset, data, DecodeReplace)
+	if err != nil {
+		return string(data)
+	}
+	return text
+}
+
+// textEncoder transcodes the text of request bodies,
+// the zero value keeps utf-8
+type textEncoder struct {
+	charset string
+	enc     *encoding.Encoder
+}
+
+func newTextEncoder(label string) (textEncoder, error) {
+	if label == "" {
+		return textEncoder{}, nil
+	}
+	charset := charsetName(label)
+	enc, err := htmlindex.Get(charset)
+	if charset == "" || err != nil || enc == encoding.Replacement {
+		return textEncoder{}, ErrUnrecognizedEncoding
+	}
+	if charset == "utf-8" {
+		return textEncoder{charset: charset}, nil
+	}
+	return textEncoder{charset: charset, enc: enc.NewEncoder()}, nil
+}
+
+func (e textEncoder) encode(s string) (string, error) {
+	if e.enc == nil {
+		return s, nil
+	}
+	encoded, err := e.enc.String(s)
+	if err != nil {
+		return "", fmt.Errorf("nic: %q can't be encoded in %s", s, e.charset)
+	}
+	return encoded, nil
+}
+
+// contentType sets the charset parameter of the media type
+func (e textEncoder) contentType(mediaType string) string {
+	if e.charset == "" {
+		return mediaType
+	}
+	t, params, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return mediaType
+	}
+	params["charset"] = e.charset
+	return mime.FormatMediaType(t, params)
+}
@@ -0,0 +1,97 @@
+package nic
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+// codecMaxDepth limits the nesting of arrays and maps as encoding/json does,
+// so that hostile data can't overflow the stack
+const codecMaxDepth = 10000
+
+// binaryHandle sets up the MessagePack and CBOR handles alike,
+// struct fields are named by the `tag` tag or the `json` tag,
+// maps are decoded into map[string]interface{} if keys are strings
+func binaryHandle(h *codec.BasicHandle, tag string) {
+	h.TypeInfos = codec.NewTypeInfos([]string{tag, "json"})
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.SignedInteger = true
+	h.MaxDepth = codecMaxDepth
+	h.CheckCircularRef = true
+}
+
+var (
+	msgpackHandle = func() *codec.MsgpackHandle {
+		h := &codec.MsgpackHandle{WriteExt: true}
+		h.RawToString = true
+		binaryHandle(&h.BasicHandle, "msgpack")
+		return h
+	}()
+
+	cborHandle = func() *codec.CborHandle {
+		h := &codec.CborHandle{}
+		binaryHandle(&h.BasicHandle, "cbor")
+		return h
+	}()
+)
+
+// codecDecode decodes by h then assigns the value to v by the same rules
+// as the other decoders, e.g. field names are matched case-insensitively
+func codecDecode(h codec.Handle, tag string, data []byte, v interface{}) error {
+	var value interface{}
+	if err := codec.NewDecoderBytes(data, h).Decode(&value); err != nil {
+		return fmt.Errorf("nic: %s", err)
+	}
+	return assign(v, value, []string{tag, "json"}, false)
+}
+
+func codecEncode(h codec.Handle, v interface{}) ([]byte, error) {
+	var data []byte
+	if err := codec.NewEncoderBytes(&data, h).Encode(v); err != nil {
+		return nil, fmt.Errorf("nic: %s", err)
+	}
+	return data, nil
+}
+
+// unmarshalMsgpack decodes MessagePack into v,
+// struct fields are matched by the `msgpack` tag, the `json` tag or the name
+func unmarshalMsgpack(data []byte, v interface{}) error {
+	return codecDecode(msgpackHandle, "msgpack", data, v)
+}
+
+// marshalMsgpack encodes v into MessagePack, time.Time is the timestamp extension
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	return codecEncode(msgpackHandle, v)
+}
+
+// unmarshalCBOR decodes CBOR into v,
+// struct fields are matched by the `cbor` tag, the `json` tag or the name
+func unmarshalCBOR(data []byte, v interface{}) error {
+	return codecDecode(cborHandle, "cbor", data, v)
+}
+
+// marshalCBOR encodes v into CBOR, time.Time is rounded to microseconds
+func marshalCBOR(v interface{}) ([]byte, error) {
+	return codecEncode(cborHandle, v)
+}
+
+// unmarshalProtobuf decodes protobuf into v, which must be a proto.Message
+func unmarshalProtobuf(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("nic: protobuf needs a proto.Message, got %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// marshalProtobuf encodes v, which must be a proto.Message
+func marshalProtobuf(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("nic: protobuf needs a proto.Message, got %T", v)
+	}
+	return proto.Marshal(m)
+}
@@ -0,0 +1,672 @@
+package nic
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Jar is the default cookie jar of Session, unlike net/http/cookiejar
+// its cookies could be listed, saved to and loaded from disk
+type Jar struct {
+	// PublicSuffixList rejects cookies whose domain is a public suffix,
+	// e.g. `Domain=co.uk`, nil means no check
+	PublicSuffixList cookiejar.PublicSuffixList
+
+	entries map[string]*jarEntry
+	seq     uint64
+	sync.Mutex
+}
+
+// jarEntry keeps all attributes of a stored cookie
+type jarEntry struct {
+	Name     string
+	Value    string
+	Domain   string
+	Path     string
+	Expires  time.Time
+	HostOnly bool
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+
+	// for sorting cookies which have the same path length
+	seq uint64
+}
+
+func (e *jarEntry) id() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+func (e *jarEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !e.Expires.After(now)
+}
+
+func (e *jarEntry) cookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     e.Name,
+		Value:    e.Value,
+		Domain:   e.Domain,
+		Path:     e.Path,
+		Expires:  e.Expires,
+		Secure:   e.Secure,
+		HttpOnly: e.HttpOnly,
+		SameSite: e.SameSite,
+	}
+}
+
+// NewJar returns an empty Jar using the public suffix list
+// of golang.org/x/net/publicsuffix
+func NewJar() *Jar {
+	return &Jar{
+		PublicSuffixList: publicsuffix.List,
+		entries:          make(map[string]*jarEntry),
+	}
+}
+
+func canonicalHost(u *url.URL) string {
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+	return strings.ToLower(host)
+}
+
+// defaultPath is the directory of request path, RFC 6265 section 5.1.4
+func defaultPath(p string) string {
+	if p == "" || p[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(p, "/")
+	if i == 0 {
+		return "/"
+	}
+	return p[:i]
+}
+
+func domainMatch(host, domain string, hostOnly bool) bool {
+	if host == domain {
+		return true
+	}
+	return !hostOnly && strings.HasSuffix(host, "."+domain)
+}
+
+func pathMatch(reqPath, cookiePath string) bool {
+	if cookiePath == "" {
+		return false
+	}
+	if reqPath == cookiePath {
+		return true
+	}
+	if strings.HasPrefix(reqPath, cookiePath) {
+		return cookiePath[len(cookiePath)-1] == '/' || reqPath[len(cookiePath)] == '/'
+	}
+	return false
+}
+
+// newEntry validates the cookie set by u, nil means it should be rejected
+func (j *Jar) newEntry(u *url.URL, c *http.Cookie, now time.Time) *jarEntry {
+	host := canonicalHost(u)
+	e := &jarEntry{
+		Name:     c.Name,
+		Value:    c.Value,
+		Path:     c.Path,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		SameSite: c.SameSite,
+	}
+
+	if e.Path == "" || e.Path[0] != '/' {
+		e.Path = defaultPath(u.Path)
+	}
+
+	domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+	switch {
+	case domain == "":
+		e.Domain, e.HostOnly = host, true
+	case net.ParseIP(host) != nil:
+		if domain != host {
+			return nil
+		}
+		e.Domain, e.HostOnly = host, true
+	case !domainMatch(host, domain, false):
+		return nil
+	case j.PublicSuffixList != nil && j.PublicSuffixList.PublicSuffix(domain) == domain:
+		// a public suffix is only allowed as a host-only cookie
+		if domain != host {
+			return nil
+		}
+		e.Domain, e.HostOnly = host, true
+	default:
+		e.Domain = domain
+	}
+
+	switch {
+	case c.MaxAge < 0:
+		e.Expires = now
+	case c.MaxAge > 0:
+		e.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+	case !c.Expires.IsZero():
+		e.Expires = c.Expires
+	}
+	return e
+}
+
+// SetCookies implements the http.CookieJar interface
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	now := time.Now()
+	for _, c := range cookies {
+		e := j.newEntry(u, c, now)
+		if e != nil {
+			j.store(e, now)
+		}
+	}
+}
+
+// store adds or removes the entry, the lock must be held
+func (j *Jar) store(e *jarEntry, now time.Time) {
+	id := e.id()
+	if e.expired(now) {
+		delete(j.entries, id)
+		return
+	}
+	if old, ok := j.entries[id]; ok {
+		e.seq = old.seq
+	} else {
+		j.seq++
+		e.seq = j.seq
+	}
+	j.entries[id] = e
+}
+
+// Cookies implements the http.CookieJar interface
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	entries := j.matching(u)
+	cookies := make([]*http.Cookie, len(entries))
+	for i, e := range entries {
+		cookies[i] = &http.Cookie{Name: e.Name, Value: e.Value}
+	}
+	return cookies
+}
+
+// matching returns entries which should be sent to u
+func (j *Jar) matching(u *url.URL) []*jarEntry {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+
+	host := canonicalHost(u)
+	https := u.Scheme == "https"
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	now := time.Now()
+	selected := make([]*jarEntry, 0)
+	for id, e := range j.entries {
+		if e.expired(now) {
+			delete(j.entries, id)
+			continue
+		}
+		if e.Secure && !https {
+			continue
+		}
+		if !domainMatch(host, e.Domain, e.HostOnly) || !pathMatch(p, e.Path) {
+			continue
+		}
+		selected = append(selected, e)
+	}
+
+	// longer paths first, then earlier creation first
+	sort.Slice(selected, func(a, b int) bool {
+		if len(selected[a].Path) != len(selected[b].Path) {
+			return len(selected[a].Path) > len(selected[b].Path)
+		}
+		return selected[a].seq < selected[b].seq
+	})
+	return selected
+}
+
+// all returns unexpired entries sorted by creation
+func (j *Jar) all() []*jarEntry {
+	j.Lock()
+	defer j.Unlock()
+	now := time.Now()
+	entries := make([]*jarEntry, 0, len(j.entries))
+	for id, e := range j.entries {
+		if e.expired(now) {
+			delete(j.entries, id)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].seq < entries[b].seq
+	})
+	return entries
+}
+
+// All returns all stored cookies with their attributes,
+// the Domain of a host-only cookie is the host itself
+func (j *Jar) All() []*http.Cookie {
+	entries := j.all()
+	cookies := make([]*http.Cookie, len(entries))
+	for i, e := range entries {
+		cookies[i] = e.cookie()
+	}
+	return cookies
+}
+
+// Delete removes the cookies named `name` which would be sent to u
+func (j *Jar) Delete(u *url.URL, name string) {
+	entries := j.matching(u)
+
+	j.Lock()
+	defer j.Unlock()
+	for _, e := range entries {
+		if e.Name == name {
+			delete(j.entries, e.id())
+		}
+	}
+}
+
+// Clear removes all cookies
+func (j *Jar) Clear() {
+	j.Lock()
+	defer j.Unlock()
+	j.entries = make(map[string]*jarEntry)
+}
+
+// clone returns a deep copy of j
+func (j *Jar) clone() *Jar {
+	j.Lock()
+	defer j.Unlock()
+	c := &Jar{
+		PublicSuffixList: j.PublicSuffixList,
+		entries:          make(map[string]*jarEntry, len(j.entries)),
+		seq:              j.seq,
+	}
+	for id, e := range j.entries {
+		copied := *e
+		c.entries[id] = &copied
+	}
+	return c
+}
+
+// jsonCookie is the JSON format of nic's persistent cookies
+type jsonCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Domain   string `json:"domain"`
+	Path     string `json:"path"`
+	Expires  int64  `json:"expires,omitempty"`
+	HostOnly bool   `json:"hostOnly"`
+	Secure   bool   `json:"secure"`
+	HttpOnly bool   `json:"httpOnly"`
+	SameSite int    `json:"sameSite,omitempty"`
+}
+
+// WriteJSON writes all cookies as JSON,
+// the `expires` field is a unix timestamp, omitted for session cookies
+func (j *Jar) WriteJSON(w io.Writer) error {
+	cookies := make([]jsonCookie, 0)
+	for _, e := range j.all() {
+		c := jsonCookie{
+			Name:     e.Name,
+			Value:    e.Value,
+			Domain:   e.Domain,
+			Path:     e.Path,
+			HostOnly: e.HostOnly,
+			Secure:   e.Secure,
+			HttpOnly: e.HttpOnly,
+			SameSite: int(e.SameSite),
+		}
+		if !e.Expires.IsZero() {
+			c.Expires = e.Expires.Unix()
+		}
+		cookies = append(cookies, c)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(cookies)
+}
+
+// ReadJSON loads cookies written by WriteJSON
+func (j *Jar) ReadJSON(r io.Reader) error {
+	cookies := make([]jsonCookie, 0)
+	err := json.NewDecoder(r).Decode(&cookies)
+	if err != nil {
+		return err
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	now := time.Now()
+	for _, c := range cookies {
+		e := &jarEntry{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   strings.ToLower(strings.TrimPrefix(c.Domain, ".")),
+			Path:     c.Path,
+			HostOnly: c.HostOnly,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			SameSite: http.SameSite(c.SameSite),
+		}
+		if c.Expires != 0 {
+			e.Expires = time.Unix(c.Expires, 0)
+		}
+		if e.Path == "" || e.Path[0] != '/' {
+			e.Path = "/"
+		}
+		j.store(e, now)
+	}
+	return nil
+}
+
+const netscapeHeader = "# Netscape HTTP Cookie File\n"
+
+// WriteNetscape writes all cookies in the Netscape `cookies.txt` format
+// which is used by curl and wget, session cookies have 0 expiry
+func (j *Jar) WriteNetscape(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(netscapeHeader)
+	for _, e := range j.all() {
+		domain, subdomains := e.Domain, "FALSE"
+		if !e.HostOnly {
+			domain, subdomains = "."+domain, "TRUE"
+		}
+		if e.HttpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expires int64
+		if !e.Expires.IsZero() {
+			expires = e.Expires.Unix()
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, subdomains, e.Path, strings.ToUpper(strconv.FormatBool(e.Secure)),
+			expires, e.Name, e.Value)
+	}
+	return bw.Flush()
+}
+
+// ReadNetscape loads cookies in the Netscape `cookies.txt` format
+func (j *Jar) ReadNetscape(r io.Reader) error {
+	entries, err := parseNetscape(r)
+	if err != nil {
+		return err
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	now := time.Now()
+	for _, e := range entries {
+		j.store(e, now)
+	}
+	return nil
+}
+
+func parseNetscape(r io.Reader) ([]*jarEntry, error) {
+	entries := make([]*jarEntry, 0)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := false
+		if strings.HasPrefix(text, "#HttpOnly_") {
+			text = text[len("#HttpOnly_"):]
+			httpOnly = true
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) == 6 {
+			// empty value may lose the last tab
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("nic: cookies.txt line %d: expected 7 fields, got %d", line, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("nic: cookies.txt line %d: invalid expiry %q", line, fields[4])
+		}
+
+		e := &jarEntry{
+			Domain:   strings.ToLower(strings.TrimPrefix(fields[0], ".")),
+			HostOnly: strings.ToUpper(fields[1]) != "TRUE",
+			Path:     fields[2],
+			Secure:   strings.ToUpper(fields[3]) == "TRUE",
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if e.Path == "" || e.Path[0] != '/' {
+			e.Path = "/"
+		}
+		if expires != 0 {
+			e.Expires = time.Unix(expires, 0)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// isNetscapeFile chooses the format by file extension,
+// `.txt` is Netscape `cookies.txt`, others are JSON
+func isNetscapeFile(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".txt"
+}
+
+func (s *Session) jar() (*Jar, error) {
+	if s.Client == nil {
+		return nil, ErrJarNotSupported
+	}
+	jar, ok := s.Client.Jar.(*Jar)
+	if !ok {
+		return nil, ErrJarNotSupported
+	}
+	return jar, nil
+}
+
+func saveJar(jar *Jar, path string) error {
+	// write to a temporary file first, so the old file
+	// is never left half-written
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if isNetscapeFile(path) {
+		err = jar.WriteNetscape(tmp)
+	} else {
+		err = jar.WriteJSON(tmp)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// SaveCookies saves the session's cookies to a file,
+// `.txt` file is in the Netscape `cookies.txt` format, others are JSON
+func (s *Session) SaveCookies(path string) error {
+	s.Lock()
+	defer s.Unlock()
+	jar, err := s.jar()
+	if err != nil {
+		return err
+	}
+	return saveJar(jar, path)
+}
+
+// LoadCookies loads cookies from a file written by SaveCookies
+func (s *Session) LoadCookies(path string) error {
+	s.Lock()
+	defer s.Unlock()
+	jar, err := s.jar()
+	if err != nil {
+		return err
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	if isNetscapeFile(path) {
+		return jar.ReadNetscape(fp)
+	}
+	return jar.ReadJSON(fp)
+}
+
+// AutoSaveCookies saves cookies to the file after every response,
+// pass an empty path to disable it, a failed save returns the response
+// together with the error
+func (s *Session) AutoSaveCookies(path string) {
+	s.Lock()
+	defer s.Unlock()
+	s.cookieFile = path
+}
+
+// Cookies returns the cookies with their attributes
+// which would be sent to urlStr
+func (s *Session) Cookies(urlStr string) ([]*http.Cookie, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	jar, err := s.jar()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := jar.matching(u)
+	cookies := make([]*http.Cookie, len(entries))
+	for i, e := range entries {
+		cookies[i] = e.cookie()
+	}
+	return cookies, nil
+}
+
+// SetCookie stores the cookie as if it's set by urlStr's response
+func (s *Session) SetCookie(urlStr string, cookie *http.Cookie) error {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	jar, err := s.jar()
+	if err != nil {
+		return err
+	}
+	jar.SetCookies(u, []*http.Cookie{cookie})
+	return nil
+}
+
+// DeleteCookie removes the cookies named `name` which would be sent to urlStr
+func (s *Session) DeleteCookie(urlStr string, name string) error {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	jar, err := s.jar()
+	if err != nil {
+		return err
+	}
+	jar.Delete(u, name)
+	return nil
+}
+
+// ClearCookies removes all cookies of the session
+func (s *Session) ClearCookies() error {
+	s.Lock()
+	defer s.Unlock()
+	jar, err := s.jar()
+	if err != nil {
+		return err
+	}
+	jar.Clear()
+	return nil
+}
+
+// RangeCookies calls fn for every stored cookie in creation order,
+// stop iterating if fn returns false
+func (s *Session) RangeCookies(fn func(*http.Cookie) bool) error {
+	s.Lock()
+	jar, err := s.jar()
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range jar.All() {
+		if !fn(c) {
+			break
+		}
+	}
+	return nil
+}
+
+// CloneCookies replaces dst's cookies with a copy of the session's cookies
+func (s *Session) CloneCookies(dst *Session) error {
+	s.Lock()
+	jar, err := s.jar()
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dst.Lock()
+	defer dst.Unlock()
+	if dst.Client == nil {
+		dst.Client = NewSession().Client
+	}
+	dst.Client.Jar = jar.clone()
+	return nil
+}
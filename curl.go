@@ -0,0 +1,419 @@
+package nic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// splitShell splits a command line like a POSIX shell,
+// single, double and ANSI-C $'...' quotes and line continuation are supported
+func splitShell(cmd string) ([]string, error) {
+	args := make([]string, 0)
+	cur := &strings.Builder{}
+	inArg := false
+
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case c == '\\' && i+1 < len(cmd):
+			i++
+			if cmd[i] != '\n' && cmd[i] != '\r' {
+				cur.WriteByte(cmd[i])
+				inArg = true
+			} else if cmd[i] == '\r' && i+1 < len(cmd) && cmd[i+1] == '\n' {
+				i++
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(cmd[i+1:], '\'')
+			if end < 0 {
+				return nil, ErrCurlSyntax
+			}
+			cur.WriteString(cmd[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+
+		case c == '$' && i+1 < len(cmd) && cmd[i+1] == '\'':
+			// ANSI-C quoting, used by browsers' `copy as cURL`
+			i += 2
+			for ; i < len(cmd) && cmd[i] != '\''; i++ {
+				if cmd[i] == '\\' && i+1 < len(cmd) {
+					i++
+					switch cmd[i] {
+					case 'n':
+						cur.WriteByte('\n')
+					case 'r':
+						cur.WriteByte('\r')
+					case 't':
+						cur.WriteByte('\t')
+					default:
+						cur.WriteByte(cmd[i])
+					}
+					continue
+				}
+				cur.WriteByte(cmd[i])
+			}
+			if i >= len(cmd) {
+				return nil, ErrCurlSyntax
+			}
+			inArg = true
+
+		case c == '"':
+			i++
+			for ; i < len(cmd) && cmd[i] != '"'; i++ {
+				if cmd[i] == '\\' && i+1 < len(cmd) && strings.IndexByte("\"\\$`\n", cmd[i+1]) >= 0 {
+					i++
+					if cmd[i] == '\n' {
+						continue
+					}
+				}
+				cur.WriteByte(cmd[i])
+			}
+			if i >= len(cmd) {
+				return nil, ErrCurlSyntax
+			}
+			inArg = true
+
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// curl options without argument which don't change the request
+var curlIgnoredFlags = map[string]bool{
+	"-s": true, "--silent": true, "-S": true, "--show-error": true,
+	"-v": true, "--verbose": true, "-i": true, "--include": true,
+	"-f": true, "--fail": true, "-#": true,
+	"--progress-bar": true, "-N": true, "--no-buffer": true,
+}
+
+// curl options which take an argument
+var curlArgFlags = map[string]string{
+	"-X": "-X", "--request": "-X",
+	"-H": "-H", "--header": "-H",
+	"-d": "-d", "--data": "-d", "--data-ascii": "-d",
+	"--data-binary": "--data-binary", "--data-raw": "--data-raw",
+	"-F": "-F", "--form": "-F",
+	"-u": "-u", "--user": "-u",
+	"-x": "-x", "--proxy": "-x",
+	"-b": "-b", "--cookie": "-b",
+	"-A": "-A", "--user-agent": "-A",
+	"-e": "-e", "--referer": "-e",
+	"-m": "-m", "--max-time": "-m",
+	"--data-urlencode": "--data-urlencode", "--url": "--url",
+}
+
+func readCurlData(v string) (string, error) {
+	if strings.HasPrefix(v, "@") {
+		data, err := ioutil.ReadFile(v[1:])
+		return string(data), err
+	}
+	return v, nil
+}
+
+// ParseCurl converts a curl command line into the arguments of Session.Request,
+// e.g. the one copied from browser devtools
+//
+//	method, url, h, err := nic.ParseCurl(`curl -X POST -d 'a=1' https://example.com`)
+//	resp, err := session.Request(method, url, h)
+func ParseCurl(cmd string) (method, urlStr string, h H, err error) {
+	args, err := splitShell(cmd)
+	if err != nil {
+		return "", "", H{}, err
+	}
+	if len(args) == 0 || args[0] != "curl" {
+		return "", "", H{}, ErrCurlSyntax
+	}
+
+	h = H{Headers: KV{}}
+	data := make([]string, 0)
+	head, get, compressed := false, false, false
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			urlStr = arg
+			continue
+		}
+
+		flag, ok := curlArgFlags[arg]
+		value := ""
+		if !ok && len(arg) > 2 && arg[1] != '-' {
+			// `-XPOST` or `-sSL`
+			if f, ok2 := curlArgFlags[arg[:2]]; ok2 {
+				flag, ok, value = f, true, arg[2:]
+			}
+		}
+		if ok && value == "" {
+			if i+1 >= len(args) {
+				return "", "", H{}, fmt.Errorf("nic: curl option %s needs an argument", arg)
+			}
+			i++
+			value = args[i]
+		}
+
+		if !ok {
+			flags := []string{arg}
+			if arg[1] != '-' && len(arg) > 2 {
+				flags = flags[:0]
+				for _, c := range arg[1:] {
+					flags = append(flags, "-"+string(c))
+				}
+			}
+			for _, f := range flags {
+				switch {
+				case curlIgnoredFlags[f]:
+				case f == "-k" || f == "--insecure":
+					h.SkipVerifyTLS = true
+				case f == "-L" || f == "--location":
+					h.AllowRedirect = true
+				case f == "-I" || f == "--head":
+					head = true
+				case f == "-G" || f == "--get":
+					get = true
+				case f == "--compressed":
+					compressed = true
+				default:
+					return "", "", H{}, fmt.Errorf("nic: unsupported curl option %s", f)
+				}
+			}
+			continue
+		}
+
+		switch flag {
+		case "-X":
+			method = strings.ToUpper(value)
+		case "-H":
+			kv := strings.SplitN(value, ":", 2)
+			if len(kv) != 2 {
+				return "", "", H{}, fmt.Errorf("nic: invalid curl header %q", value)
+			}
+			h.Headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		case "-A":
+			h.Headers["User-Agent"] = value
+		case "-e":
+			h.Headers["Referer"] = value
+		case "-d", "--data-binary":
+			v, err := readCurlData(value)
+			if err != nil {
+				return "", "", H{}, err
+			}
+			if flag == "-d" {
+				v = strings.NewReplacer("\r", "", "\n", "").Replace(v)
+			}
+			data = append(data, v)
+		case "--data-raw":
+			data = append(data, value)
+		case "--data-urlencode":
+			name, content := "", value
+			if i := strings.IndexAny(value, "=@"); i >= 0 {
+				name, content = value[:i], value[i+1:]
+				if value[i] == '@' {
+					b, err := ioutil.ReadFile(content)
+					if err != nil {
+						return "", "", H{}, err
+					}
+					content = string(b)
+				}
+			}
+			// curl escapes space as %20 rather than +
+			content = strings.Replace(url.QueryEscape(content), "+", "%20", -1)
+			if name == "" {
+				data = append(data, content)
+			} else {
+				data = append(data, name+"="+content)
+			}
+		case "-F":
+			if h.Files == nil {
+				h.Files = KV{}
+			}
+			kv := strings.SplitN(value, "=", 2)
+			if len(kv) != 2 {
+				return "", "", H{}, fmt.Errorf("nic: invalid curl form %q", value)
+			}
+			if strings.HasPrefix(kv[1], "@") || strings.HasPrefix(kv[1], "<") {
+				params := strings.Split(kv[1][1:], ";")
+				f := FileFromPath(params[0])
+				for _, p := range params[1:] {
+					switch {
+					case strings.HasPrefix(p, "type="):
+						f.MIME(p[5:])
+					case strings.HasPrefix(p, "filename="):
+						f.FName(strings.Trim(p[9:], `"`))
+					}
+				}
+				h.Files[kv[0]] = f
+			} else {
+				h.Files[kv[0]] = kv[1]
+			}
+		case "-u":
+			kv := strings.SplitN(value, ":", 2)
+			if len(kv) == 1 {
+				kv = append(kv, "")
+			}
+			h.Auth = BasicAuth(kv[0], kv[1])
+		case "-x":
+			if !strings.Contains(value, "://") {
+				value = "http://" + value
+			}
+			h.Proxy = value
+		case "-b":
+			if !strings.Contains(value, "=") {
+				return "", "", H{}, fmt.Errorf("nic: curl cookie file %s is not supported, use Session.LoadCookies", value)
+			}
+			if h.Cookies == nil {
+				h.Cookies = KV{}
+			}
+			for _, pair := range strings.Split(value, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 {
+					h.Cookies[kv[0]] = kv[1]
+				}
+			}
+		case "-m":
+			var seconds float64
+			_, err := fmt.Sscanf(value, "%g", &seconds)
+			if err != nil {
+				return "", "", H{}, fmt.Errorf("nic: invalid curl max-time %q", value)
+			}
+			h.Timeout = int64(seconds + 0.999)
+		case "--url":
+			urlStr = value
+		}
+	}
+
+	if urlStr == "" {
+		return "", "", H{}, fmt.Errorf("nic: curl command has no url")
+	}
+	if !strings.Contains(urlStr, "://") {
+		urlStr = "http://" + urlStr
+	}
+
+	if len(data) > 0 {
+		if get {
+			sep := "?"
+			if strings.Contains(urlStr, "?") {
+				sep = "&"
+			}
+			urlStr += sep + strings.Join(data, "&")
+		} else {
+			h.Raw = strings.Join(data, "&")
+			if _, ok := h.Headers["Content-Type"]; !ok {
+				h.Headers["Content-Type"] = "application/x-www-form-urlencoded"
+			}
+		}
+	}
+
+	if compressed {
+		// the transport asks for gzip and decodes the response transparently,
+		// which is disabled by an explicit Accept-Encoding, e.g. `br` of browsers
+		for k := range h.Headers {
+			if strings.EqualFold(k, "Accept-Encoding") {
+				delete(h.Headers, k)
+			}
+		}
+		h.DisableCompression = false
+	}
+
+	switch {
+	case method != "":
+	case head:
+		method = HEAD
+	case h.Raw != "" || h.Files != nil:
+		method = POST
+	default:
+		method = GET
+	}
+
+	if h.isConflict() {
+		return "", "", H{}, ErrParamConflict
+	}
+	return method, urlStr, h, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Curl returns an equivalent curl command of the request
+func Curl(req *http.Request) (string, error) {
+	b := &strings.Builder{}
+	b.WriteString("curl")
+
+	switch req.Method {
+	case "", GET:
+	case HEAD:
+		b.WriteString(" -I")
+	default:
+		b.WriteString(" -X " + req.Method)
+	}
+	b.WriteString(" " + shellQuote(req.URL.String()))
+
+	if req.Host != "" && req.Host != req.URL.Host {
+		b.WriteString(" -H " + shellQuote("Host: "+req.Host))
+	}
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "Content-Length" {
+			continue
+		}
+		for _, v := range req.Header[k] {
+			b.WriteString(" -H " + shellQuote(k+": "+v))
+		}
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return "", err
+		}
+		if len(data) > 0 {
+			b.WriteString(" --data-binary " + shellQuote(string(data)))
+		}
+	}
+	return b.String(), nil
+}
+
+// Curl returns an equivalent curl command of the request
+// which gets the response, e.g. for bug reports
+func (r *Response) Curl() (string, error) {
+	if r.Request == nil {
+		return "", ErrNoRequest
+	}
+	return Curl(r.Request)
+}
+
+// Curl returns an equivalent curl command of the last request
+func (s *Session) Curl() (string, error) {
+	if s.request == nil {
+		return "", ErrNoRequest
+	}
+	return Curl(s.request)
+}
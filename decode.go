@@ -0,0 +1,473 @@
+package nic
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/encoding/htmlindex"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DecodeFunc decodes a response body into v,
+// json.Unmarshal, xml.Unmarshal and yaml.Unmarshal are all DecodeFuncs
+type DecodeFunc func(data []byte, v interface{}) error
+
+// MediaTypeError will be returned by Response.Decode or a request
+// if no decoder or encoder is registered for the media type
+type MediaTypeError struct {
+	MediaType string
+}
+
+func (e *MediaTypeError) Error() string {
+	return fmt.Sprintf("nic: Unsupported media type %q", e.MediaType)
+}
+
+var (
+	decodersMu sync.RWMutex
+
+	// keys are media types, or structured syntax suffixes as "+json"
+	decoders = map[string]DecodeFunc{
+		"application/json":                  json.Unmarshal,
+		"text/json":                         json.Unmarshal,
+		"+json":                             json.Unmarshal,
+		"application/xml":                   unmarshalXML,
+		"text/xml":                          unmarshalXML,
+		"+xml":                              unmarshalXML,
+		"application/yaml":                  yaml.Unmarshal,
+		"application/x-yaml":                yaml.Unmarshal,
+		"text/yaml":                         yaml.Unmarshal,
+		"text/x-yaml":                       yaml.Unmarshal,
+		"+yaml":                             yaml.Unmarshal,
+		"application/x-www-form-urlencoded": unmarshalForm,
+		"application/msgpack":               unmarshalMsgpack,
+		"application/x-msgpack":             unmarshalMsgpack,
+		"application/vnd.msgpack":           unmarshalMsgpack,
+		"+msgpack":                          unmarshalMsgpack,
+		"application/cbor":                  unmarshalCBOR,
+		"+cbor":                             unmarshalCBOR,
+		"application/protobuf":              unmarshalProtobuf,
+		"application/x-protobuf":            unmarshalProtobuf,
+		"application/vnd.google.protobuf":   unmarshalProtobuf,
+	}
+)
+
+// RegisterDecoder registers the decoder of a media type for Response.Decode,
+// a structured syntax suffix as "+cbor" matches all the types ending with it,
+// pass nil to unregister it
+func RegisterDecoder(mediaType string, fn DecodeFunc) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if fn == nil {
+		delete(decoders, mediaType)
+		return
+	}
+	decoders[mediaType] = fn
+}
+
+// lookupDecoder finds the decoder of the media type, then of its suffix
+func lookupDecoder(mediaType string) DecodeFunc {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	if fn, ok := decoders[mediaType]; ok {
+		return fn
+	}
+	if i := strings.LastIndexByte(mediaType, '+'); i >= 0 {
+		return decoders[mediaType[i:]]
+	}
+	return nil
+}
+
+// mediaType returns the lowercase media type of Content-Type
+func (r Response) mediaType() string {
+	if r.Response == nil {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// Decode decodes the body into v by the decoder registered for Content-Type,
+// JSON, XML, YAML, form and MessagePack are registered by default.
+// If no decoder matches, e.g. `text/plain`, a body looking like
+// JSON or XML is decoded as it unless mode is DecodeStrict,
+// then a *MediaTypeError is returned
+//
+//	s := &S{}
+//	err := resp.Decode(s)
+func (r Response) Decode(v interface{}, mode ...DecodeMode) error {
+	mediaType := r.mediaType()
+	if fn := lookupDecoder(mediaType); fn != nil {
+		return fn(r.Bytes, v)
+	}
+
+	if len(mode) == 0 || mode[0] != DecodeStrict {
+		trimmed := bytes.TrimLeft(r.Bytes, " \t\r\n\xef\xbb\xbf")
+		switch {
+		case bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("[")):
+			return json.Unmarshal(r.Bytes, v)
+		case bytes.HasPrefix(trimmed, []byte("<")):
+			return unmarshalXML(r.Bytes, v)
+		}
+	}
+	return &MediaTypeError{MediaType: mediaType}
+}
+
+// isJSON reports whether the media type is JSON
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || mediaType == "text/json" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// unmarshalXML decodes XML declared in any WHATWG encoding
+func unmarshalXML(data []byte, v interface{}) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = func(label string, r io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, ErrUnrecognizedEncoding
+		}
+		return enc.NewDecoder().Reader(r), nil
+	}
+	return decoder.Decode(v)
+}
+
+// unmarshalForm decodes a urlencoded form into *url.Values, a map or a struct,
+// struct fields are matched by the `form` tag or the name,
+// the first value is taken unless the field is a slice
+func unmarshalForm(data []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	if p, ok := v.(*url.Values); ok {
+		*p = values
+		return nil
+	}
+
+	m := make(map[string]interface{}, len(values))
+	for k, vs := range values {
+		a := make([]interface{}, len(vs))
+		for i, s := range vs {
+			a[i] = s
+		}
+		m[k] = a
+	}
+	return assign(v, m, []string{"form"}, true)
+}
+
+// assign stores the decoded src into the pointer v,
+// weak converts strings to numbers and bools, and
+// collapses a slice into its first element for a non-slice destination
+func assign(v interface{}, src interface{}, tags []string, weak bool) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("nic: Decode needs a non-nil pointer, got %T", v)
+	}
+	a := &assigner{tags: tags, weak: weak}
+	return a.assign(rv.Elem(), src)
+}
+
+type assigner struct {
+	tags []string
+	weak bool
+}
+
+func (a *assigner) mismatch(dst reflect.Value, src interface{}) error {
+	return fmt.Errorf("nic: Cannot decode %T into %s", src, dst.Type())
+}
+
+func (a *assigner) assign(dst reflect.Value, src interface{}) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	if list, ok := src.([]interface{}); ok && a.weak {
+		switch dst.Kind() {
+		case reflect.Slice, reflect.Array:
+		default:
+			if dst.Kind() != reflect.Interface || len(list) == 1 {
+				if len(list) == 0 {
+					return nil
+				}
+				src = list[0]
+			}
+		}
+	}
+
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return a.assign(dst.Elem(), src)
+	}
+
+	if s, ok := src.(string); ok && dst.CanAddr() {
+		if u, ok := dst.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+
+	switch dst.Kind() {
+	case reflect.Bool:
+		switch s := src.(type) {
+		case bool:
+			dst.SetBool(s)
+			return nil
+		case string:
+			if a.weak {
+				b, err := strconv.ParseBool(s)
+				if err != nil {
+					return err
+				}
+				dst.SetBool(b)
+				return nil
+			}
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch s := src.(type) {
+		case int64:
+			i = s
+		case uint64:
+			if s > 1<<63-1 {
+				return fmt.Errorf("nic: %d overflows %s", s, dst.Type())
+			}
+			i = int64(s)
+		case float64:
+			if s != float64(int64(s)) {
+				return a.mismatch(dst, src)
+			}
+			i = int64(s)
+		case string:
+			if !a.weak {
+				return a.mismatch(dst, src)
+			}
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			i = n
+		default:
+			return a.mismatch(dst, src)
+		}
+		if dst.OverflowInt(i) {
+			return fmt.Errorf("nic: %d overflows %s", i, dst.Type())
+		}
+		dst.SetInt(i)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch s := src.(type) {
+		case uint64:
+			u = s
+		case int64:
+			if s < 0 {
+				return fmt.Errorf("nic: %d overflows %s", s, dst.Type())
+			}
+			u = uint64(s)
+		case float64:
+			if s < 0 || s != float64(uint64(s)) {
+				return a.mismatch(dst, src)
+			}
+			u = uint64(s)
+		case string:
+			if !a.weak {
+				return a.mismatch(dst, src)
+			}
+			n, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			u = n
+		default:
+			return a.mismatch(dst, src)
+		}
+		if dst.OverflowUint(u) {
+			return fmt.Errorf("nic: %d overflows %s", u, dst.Type())
+		}
+		dst.SetUint(u)
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		switch s := src.(type) {
+		case float64:
+			dst.SetFloat(s)
+			return nil
+		case int64:
+			dst.SetFloat(float64(s))
+			return nil
+		case uint64:
+			dst.SetFloat(float64(s))
+			return nil
+		case string:
+			if a.weak {
+				f, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					return err
+				}
+				dst.SetFloat(f)
+				return nil
+			}
+		}
+
+	case reflect.String:
+		if b, ok := src.([]byte); ok {
+			dst.SetString(string(b))
+			return nil
+		}
+		if s, ok := src.(string); ok {
+			dst.SetString(s)
+			return nil
+		}
+
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			if s, ok := src.(string); ok {
+				dst.SetBytes([]byte(s))
+				return nil
+			}
+		}
+		list, ok := src.([]interface{})
+		if !ok {
+			break
+		}
+		s := reflect.MakeSlice(dst.Type(), len(list), len(list))
+		for i, e := range list {
+			if err := a.assign(s.Index(i), e); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+		return nil
+
+	case reflect.Array:
+		list, ok := src.([]interface{})
+		if !ok || len(list) != dst.Len() {
+			break
+		}
+		for i, e := range list {
+			if err := a.assign(dst.Index(i), e); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		entries := mapEntries(src)
+		if entries == nil {
+			break
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(dst.Type(), len(entries)))
+		}
+		for k, e := range entries {
+			key := reflect.New(dst.Type().Key()).Elem()
+			if err := a.assign(key, k); err != nil {
+				return err
+			}
+			value := reflect.New(dst.Type().Elem()).Elem()
+			if err := a.assign(value, e); err != nil {
+				return err
+			}
+			dst.SetMapIndex(key, value)
+		}
+		return nil
+
+	case reflect.Struct:
+		entries := mapEntries(src)
+		if entries == nil {
+			break
+		}
+		return a.assignStruct(dst, entries)
+	}
+	return a.mismatch(dst, src)
+}
+
+// mapEntries returns the entries of a decoded map, or nil if src isn't a map
+func mapEntries(src interface{}) map[interface{}]interface{} {
+	switch m := src.(type) {
+	case map[interface{}]interface{}:
+		return m
+	case map[string]interface{}:
+		entries := make(map[interface{}]interface{}, len(m))
+		for k, v := range m {
+			entries[k] = v
+		}
+		return entries
+	}
+	return nil
+}
+
+// assignStruct matches the entries by the tags or the field name in any case,
+// untagged embedded structs are flattened
+func (a *assigner) assignStruct(dst reflect.Value, entries map[interface{}]interface{}) error {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := ""
+		for _, tag := range a.tags {
+			if name = strings.Split(f.Tag.Get(tag), ",")[0]; name != "" {
+				break
+			}
+		}
+		if name == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+
+		field := dst.Field(i)
+		if f.Anonymous && name == "" {
+			if f.Type.Kind() == reflect.Struct {
+				if err := a.assignStruct(field, entries); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		value, ok := entries[name]
+		if !ok {
+			for k, e := range entries {
+				if s, isString := k.(string); isString && strings.EqualFold(s, name) {
+					value, ok = e, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := a.assign(field, value); err != nil {
+			return fmt.Errorf("nic: Field %s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package nic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// DumpOptions controls what a wire dump contains,
+// Authorization, Proxy-Authorization and cookie values are always redacted
+type DumpOptions struct {
+	// Body dumps request and response bodies too
+	Body bool
+
+	// Redact are extra secret names, matching header fields,
+	// query params, form fields and JSON object keys are redacted
+	Redact []string
+}
+
+var alwaysRedacted = []string{"Authorization", "Proxy-Authorization"}
+
+func (o DumpOptions) secret(name string) bool {
+	for _, s := range alwaysRedacted {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	for _, s := range o.Redact {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactPairs redacts the values of `a=1&b=2` formed data
+func (o DumpOptions) redactPairs(s string) string {
+	pairs := strings.Split(s, "&")
+	for i, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		name, err := url.QueryUnescape(kv[0])
+		if err != nil {
+			name = kv[0]
+		}
+		if len(kv) == 2 && o.secret(name) {
+			pairs[i] = kv[0] + "=" + url.QueryEscape(redacted)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (o DumpOptions) redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if o.secret(k) {
+				v[k] = redacted
+			} else {
+				v[k] = o.redactJSON(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = o.redactJSON(e)
+		}
+	}
+	return v
+}
+
+// redactCookies keeps cookie names but hides their values
+func redactCookies(s string, set bool) string {
+	parts := strings.Split(s, ";")
+	if set {
+		// only the first pair of Set-Cookie is the cookie
+		parts = parts[:1]
+	}
+	for i, part := range parts {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			parts[i] = kv[0] + "=" + redacted
+		}
+	}
+	if set {
+		return parts[0] + s[strings.IndexByte(s+";", ';'):]
+	}
+	return strings.Join(parts, ";")
+}
+
+func (o DumpOptions) redactHeader(k, v string) string {
+	switch {
+	case o.secret(k):
+		return redacted
+	case http.CanonicalHeaderKey(k) == "Cookie":
+		return redactCookies(v, false)
+	case http.CanonicalHeaderKey(k) == "Set-Cookie":
+		return redactCookies(v, true)
+	}
+	return v
+}
+
+func (o DumpOptions) writeHeader(b *bytes.Buffer, prefix string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(b, "%s %s: %s\r\n", prefix, k, o.redactHeader(k, v))
+		}
+	}
+	fmt.Fprintf(b, "%s\r\n", prefix)
+}
+
+// redactBody redacts the secret fields of urlencoded form and JSON bodies
+func (o DumpOptions) redactBody(contentType string, body []byte) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return []byte(o.redactPairs(string(body)))
+	case strings.HasSuffix(mediaType, "json") && len(o.Redact) > 0:
+		var v interface{}
+		if json.Unmarshal(body, &v) == nil {
+			if data, err := json.Marshal(o.redactJSON(v)); err == nil {
+				return data
+			}
+		}
+	}
+	return body
+}
+
+func (o DumpOptions) writeBody(b *bytes.Buffer, contentType string, body []byte) {
+	if !o.Body || len(body) == 0 {
+		return
+	}
+	if !utf8.Valid(body) {
+		fmt.Fprintf(b, "[%d bytes of binary data]\r\n", len(body))
+		return
+	}
+
+	body = o.redactBody(contentType, body)
+	b.Write(body)
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		b.WriteString("\r\n")
+	}
+}
+
+func (o DumpOptions) dumpRequest(b *bytes.Buffer, req *http.Request, body []byte) {
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	if req.URL.RawQuery != "" {
+		uri += "?" + o.redactPairs(req.URL.RawQuery)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	fmt.Fprintf(b, "> %s %s HTTP/1.1\r\n", req.Method, uri)
+	fmt.Fprintf(b, "> Host: %s\r\n", host)
+	o.writeHeader(b, ">", req.Header)
+	o.writeBody(b, req.Header.Get("Content-Type"), body)
+}
+
+func (o DumpOptions) dumpResponse(b *bytes.Buffer, resp *http.Response, body []byte) {
+	proto := resp.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	fmt.Fprintf(b, "< %s %s\r\n", proto, resp.Status)
+	o.writeHeader(b, "<", resp.Header)
+	o.writeBody(b, resp.Header.Get("Content-Type"), body)
+}
+
+// Dump writes the raw request and response in `curl -v` style, e.g.
+//
+//	resp.Dump(os.Stderr, nic.DumpOptions{Body: true, Redact: []string{"password"}})
+func (r *Response) Dump(w io.Writer, opt DumpOptions) error {
+	b := &bytes.Buffer{}
+	if r.Request != nil {
+		var body []byte
+		if r.Request.GetBody != nil {
+			rc, err := r.Request.GetBody()
+			if err != nil {
+				return err
+			}
+			body, err = ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		opt.dumpRequest(b, r.Request, body)
+	}
+	opt.dumpResponse(b, r.Response, r.Bytes)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// dumpTransport writes every request sent over the wire
+// and its response
+type dumpTransport struct {
+	base http.RoundTripper
+	w    io.Writer
+	opt  DumpOptions
+}
+
+func (t *dumpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	var err error
+	if t.opt.Body {
+		body, err = peekRequestBody(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b := &bytes.Buffer{}
+	t.opt.dumpRequest(b, req, body)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(b, "* %s\r\n", err)
+		t.w.Write(b.Bytes())
+		return nil, err
+	}
+
+	var data []byte
+	if t.opt.Body {
+		data, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	}
+	t.opt.dumpResponse(b, resp, data)
+	t.w.Write(b.Bytes())
+	return resp, nil
+}
+
+// SetDebug writes every request the session sends over the wire
+// and its response to w, including redirects and retries,
+// pass nil to disable it
+func (s *Session) SetDebug(w io.Writer, opt DumpOptions) {
+	s.Lock()
+	defer s.Unlock()
+	s.debug = w
+	s.dumpOpt = opt
+}
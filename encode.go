@@ -0,0 +1,165 @@
+package nic
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// EncodeFunc encodes H.Body,
+// json.Marshal, xml.Marshal and yaml.Marshal are all EncodeFuncs
+type EncodeFunc func(v interface{}) ([]byte, error)
+
+// bodyEncoder encodes a body, text is encoded in the charset of e,
+// it returns the body and its Content-Type, "" means the requested media type
+type bodyEncoder func(v interface{}, e textEncoder) ([]byte, string, error)
+
+// marshal adapts an EncodeFunc, the body is always utf-8
+func marshal(fn EncodeFunc) bodyEncoder {
+	return func(v interface{}, e textEncoder) ([]byte, string, error) {
+		data, err := fn(v)
+		return data, "", err
+	}
+}
+
+var (
+	encodersMu sync.RWMutex
+
+	// keys are media types, or structured syntax suffixes as "+json"
+	encoders = map[string]bodyEncoder{
+		"application/json":                  marshal(json.Marshal),
+		"text/json":                         marshal(json.Marshal),
+		"+json":                             marshal(json.Marshal),
+		"application/xml":                   marshal(xml.Marshal),
+		"text/xml":                          marshal(xml.Marshal),
+		"+xml":                              marshal(xml.Marshal),
+		"application/yaml":                  marshal(yaml.Marshal),
+		"application/x-yaml":                marshal(yaml.Marshal),
+		"text/yaml":                         marshal(yaml.Marshal),
+		"text/x-yaml":                       marshal(yaml.Marshal),
+		"+yaml":                             marshal(yaml.Marshal),
+		"application/x-www-form-urlencoded": encodeForm,
+		"multipart/form-data":               encodeMultipart,
+		"application/msgpack":               marshal(marshalMsgpack),
+		"application/x-msgpack":             marshal(marshalMsgpack),
+		"application/vnd.msgpack":           marshal(marshalMsgpack),
+		"+msgpack":                          marshal(marshalMsgpack),
+		"application/cbor":                  marshal(marshalCBOR),
+		"+cbor":                             marshal(marshalCBOR),
+		"application/protobuf":              marshal(marshalProtobuf),
+		"application/x-protobuf":            marshal(marshalProtobuf),
+		"application/vnd.google.protobuf":   marshal(marshalProtobuf),
+	}
+)
+
+// RegisterEncoder registers the encoder of a media type for H.Body, e.g.
+//
+//	nic.RegisterEncoder("application/x-amz-json-1.1", json.Marshal)
+//
+// a structured syntax suffix as "+cbor" matches all the types ending with it,
+// pass nil to unregister it
+func RegisterEncoder(mediaType string, fn EncodeFunc) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if fn == nil {
+		delete(encoders, mediaType)
+		return
+	}
+	encoders[mediaType] = marshal(fn)
+}
+
+// lookupEncoder finds the encoder of the media type, then of its suffix
+func lookupEncoder(mediaType string) bodyEncoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	if fn, ok := encoders[mediaType]; ok {
+		return fn
+	}
+	if i := strings.LastIndexByte(mediaType, '+'); i >= 0 {
+		return encoders[mediaType[i:]]
+	}
+	return nil
+}
+
+// cycleKey identifies a reference on the path being walked,
+// a slice is also told apart by its length as encoding/json does
+type cycleKey struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// checkCycle reports a body referencing itself, which would make
+// the encoders recurse until the stack overflows
+func checkCycle(v interface{}) error {
+	return walkCycle(reflect.ValueOf(v), make(map[cycleKey]bool))
+}
+
+// mayCycle tells whether values of t could hold references
+func mayCycle(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		return true
+	}
+	return false
+}
+
+func walkCycle(v reflect.Value, path map[cycleKey]bool) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		key := cycleKey{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			key.len = v.Len()
+		}
+		if path[key] {
+			return ErrBodyCycle
+		}
+		path[key] = true
+		defer delete(path, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			return walkCycle(v.Elem(), path)
+		}
+	case reflect.Map:
+		if !mayCycle(v.Type().Elem()) {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := walkCycle(iter.Value(), path); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if !mayCycle(v.Type().Elem()) {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := walkCycle(v.Index(i), path); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			// encoders skip unexported fields, but not the embedded ones
+			if f := t.Field(i); f.PkgPath == "" || f.Anonymous {
+				if err := walkCycle(v.Field(i), path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
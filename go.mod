@@ -1,5 +1,13 @@
 module github.com/eddieivan01/nic
 
-go 1.12
+go 1.21
 
-require github.com/axgle/mahonia v0.0.0-20180208002826-3358181d7394
+require (
+	github.com/andybalholm/cascadia v1.1.0
+	github.com/antchfx/xpath v1.1.6
+	github.com/ugorji/go/codec v1.3.2
+	golang.org/x/net v0.0.0-20200202094626-16171245cfb2
+	golang.org/x/text v0.3.2
+	google.golang.org/protobuf v1.36.0
+	gopkg.in/yaml.v2 v2.2.8
+)
@@ -0,0 +1,599 @@
+package nic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type (
+	// HAR is the root of a HTTP Archive 1.2 file
+	HAR struct {
+		Log HARLog `json:"log"`
+	}
+
+	// HARLog is the `log` object
+	HARLog struct {
+		Version string      `json:"version"`
+		Creator HARCreator  `json:"creator"`
+		Entries []*HAREntry `json:"entries"`
+	}
+
+	// HARCreator is the `creator` object
+	HARCreator struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	// HAREntry is an exchanged request/response pair
+	HAREntry struct {
+		StartedDateTime string      `json:"startedDateTime"`
+		Time            float64     `json:"time"`
+		Request         HARRequest  `json:"request"`
+		Response        HARResponse `json:"response"`
+		Cache           struct{}    `json:"cache"`
+		Timings         HARTimings  `json:"timings"`
+		ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+		Connection      string      `json:"connection,omitempty"`
+	}
+
+	// HARRequest is the `request` object
+	HARRequest struct {
+		Method      string         `json:"method"`
+		URL         string         `json:"url"`
+		HTTPVersion string         `json:"httpVersion"`
+		Cookies     []HARCookie    `json:"cookies"`
+		Headers     []HARNameValue `json:"headers"`
+		QueryString []HARNameValue `json:"queryString"`
+		PostData    *HARPostData   `json:"postData,omitempty"`
+		HeadersSize int64          `json:"headersSize"`
+		BodySize    int64          `json:"bodySize"`
+	}
+
+	// HARResponse is the `response` object
+	HARResponse struct {
+		Status      int            `json:"status"`
+		StatusText  string         `json:"statusText"`
+		HTTPVersion string         `json:"httpVersion"`
+		Cookies     []HARCookie    `json:"cookies"`
+		Headers     []HARNameValue `json:"headers"`
+		Content     HARContent     `json:"content"`
+		RedirectURL string         `json:"redirectURL"`
+		HeadersSize int64          `json:"headersSize"`
+		BodySize    int64          `json:"bodySize"`
+	}
+
+	// HARCookie is a cookie of request or response
+	HARCookie struct {
+		Name     string `json:"name"`
+		Value    string `json:"value"`
+		Path     string `json:"path,omitempty"`
+		Domain   string `json:"domain,omitempty"`
+		Expires  string `json:"expires,omitempty"`
+		HTTPOnly bool   `json:"httpOnly,omitempty"`
+		Secure   bool   `json:"secure,omitempty"`
+	}
+
+	// HARNameValue is a header or query param
+	HARNameValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	// HARPostData is the request body
+	HARPostData struct {
+		MimeType string         `json:"mimeType"`
+		Params   []HARNameValue `json:"params,omitempty"`
+		Text     string         `json:"text"`
+	}
+
+	// HARContent is the response body
+	HARContent struct {
+		Size     int64  `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text,omitempty"`
+		Encoding string `json:"encoding,omitempty"`
+	}
+
+	// HARTimings are in milliseconds, -1 means not applicable
+	HARTimings struct {
+		Blocked float64 `json:"blocked"`
+		DNS     float64 `json:"dns"`
+		Connect float64 `json:"connect"`
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+		SSL     float64 `json:"ssl"`
+	}
+)
+
+// HARRecorder records every exchange of a Session as HAR entries,
+// redirections and authentication retries are separate entries
+//
+//	recorder := nic.NewHARRecorder()
+//	session.RecordHAR(recorder)
+//	// ......
+//	err := recorder.Save("session.har")
+type HARRecorder struct {
+	entries []*HAREntry
+	redact  []string
+
+	// streaming
+	w     io.Writer
+	count int
+	err   error
+	sync.Mutex
+}
+
+// NewHARRecorder returns a recorder keeping entries in memory
+func NewHARRecorder() *HARRecorder {
+	return &HARRecorder{
+		entries: make([]*HAREntry, 0),
+	}
+}
+
+var harCreator = HARCreator{
+	Name:    "nic",
+	Version: version,
+}
+
+// NewHARStream returns a recorder writing every entry to w continuously,
+// Close must be called to finish the HAR document
+func NewHARStream(w io.Writer) (*HARRecorder, error) {
+	creator, err := json.Marshal(harCreator)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(w, `{"log":{"version":"1.2","creator":`+string(creator)+`,"entries":[`+"\n")
+	if err != nil {
+		return nil, err
+	}
+	return &HARRecorder{w: w}, nil
+}
+
+// Redact adds header fields and query params which are recorded as `[REDACTED]`,
+// Authorization, Proxy-Authorization, Cookie and Set-Cookie headers,
+// cookies and the keys of APIKeyHeader and APIKeyQuery are always redacted
+// invoke it in a chain
+func (r *HARRecorder) Redact(names ...string) *HARRecorder {
+	r.Lock()
+	defer r.Unlock()
+	r.redact = append(r.redact, names...)
+	return r
+}
+
+// secrets returns all the names to redact
+func (r *HARRecorder) secrets(extra []string) []string {
+	r.Lock()
+	defer r.Unlock()
+	names := append([]string{}, recordRedacted...)
+	names = append(names, r.redact...)
+	return append(names, extra...)
+}
+
+func (r *HARRecorder) add(e *HAREntry) {
+	r.Lock()
+	defer r.Unlock()
+	if r.w == nil {
+		r.entries = append(r.entries, e)
+		return
+	}
+	if r.err != nil {
+		return
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		r.err = err
+		return
+	}
+	if r.count > 0 {
+		data = append([]byte(",\n"), data...)
+	}
+	r.count++
+	_, r.err = r.w.Write(data)
+}
+
+// HAR returns the recorded log, it's empty for a stream
+func (r *HARRecorder) HAR() *HAR {
+	r.Lock()
+	defer r.Unlock()
+	entries := make([]*HAREntry, len(r.entries))
+	copy(entries, r.entries)
+	return &HAR{
+		Log: HARLog{
+			Version: "1.2",
+			Creator: harCreator,
+			Entries: entries,
+		},
+	}
+}
+
+// WriteTo writes the recorded log as JSON
+func (r *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(r.HAR(), "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// Save writes the recorded log to a file
+func (r *HARRecorder) Save(path string) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = r.WriteTo(fp)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// Close finishes the HAR document of a stream,
+// it returns the first error while streaming
+func (r *HARRecorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.w == nil || r.err != nil {
+		return r.err
+	}
+	_, r.err = io.WriteString(r.w, "\n]}}\n")
+	return r.err
+}
+
+func millis(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return -1
+	}
+	return float64(to.Sub(from)) / float64(time.Millisecond)
+}
+
+// har converts the timings to the HAR format
+func (t *timer) har() HARTimings {
+	t.Lock()
+	defer t.Unlock()
+	h := HARTimings{
+		Blocked: millis(t.getConn, t.gotConn),
+		DNS:     millis(t.dnsStart, t.dnsDone),
+		Connect: millis(t.connectStart, t.connectDone),
+		SSL:     millis(t.tlsStart, t.tlsDone),
+		Send:    millis(t.gotConn, t.wroteRequest),
+		Wait:    millis(t.wroteRequest, t.firstByte),
+		Receive: millis(t.firstByte, t.done),
+	}
+
+	// blocked doesn't include dns, connect and ssl
+	for _, d := range []float64{h.DNS, h.Connect} {
+		if h.Blocked > 0 && d > 0 {
+			h.Blocked -= d
+		}
+	}
+	if h.Blocked < 0 && h.Blocked != -1 {
+		h.Blocked = 0
+	}
+	// connect includes ssl
+	if h.SSL > 0 && h.Connect > 0 {
+		h.Connect += h.SSL
+	}
+	// send, wait and receive are required
+	for _, d := range []*float64{&h.Send, &h.Wait, &h.Receive} {
+		if *d < 0 {
+			*d = 0
+		}
+	}
+	return h
+}
+
+// harHeaders converts the header, values of secret fields are redacted
+func harHeaders(h http.Header, secrets DumpOptions) []HARNameValue {
+	pairs := make([]HARNameValue, 0, len(h))
+	for k, vs := range h {
+		for _, v := range vs {
+			if secrets.secret(k) {
+				v = redacted
+			}
+			pairs = append(pairs, HARNameValue{k, v})
+		}
+	}
+	return pairs
+}
+
+// harValues converts the values, secret ones are redacted
+func harValues(values url.Values, secrets DumpOptions) []HARNameValue {
+	pairs := make([]HARNameValue, 0, len(values))
+	for k, vs := range values {
+		for _, v := range vs {
+			if secrets.secret(k) {
+				v = redacted
+			}
+			pairs = append(pairs, HARNameValue{k, v})
+		}
+	}
+	return pairs
+}
+
+// harCookies converts the cookies, values are always redacted
+func harCookies(cookies []*http.Cookie) []HARCookie {
+	hc := make([]HARCookie, len(cookies))
+	for i, c := range cookies {
+		hc[i] = HARCookie{
+			Name:     c.Name,
+			Value:    redacted,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			hc[i].Expires = c.Expires.Format(time.RFC3339)
+		}
+	}
+	return hc
+}
+
+func harRequest(req *http.Request, body []byte, secrets DumpOptions) HARRequest {
+	u := *req.URL
+	if u.RawQuery != "" {
+		u.RawQuery = secrets.redactPairs(u.RawQuery)
+	}
+	hr := HARRequest{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     harCookies(req.Cookies()),
+		Headers:     harHeaders(req.Header, secrets),
+		QueryString: harValues(req.URL.Query(), secrets),
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+	}
+	if hr.HTTPVersion == "" {
+		hr.HTTPVersion = "HTTP/1.1"
+	}
+
+	if body != nil {
+		contentType := req.Header.Get("Content-Type")
+		hr.PostData = &HARPostData{
+			MimeType: contentType,
+			Text:     string(secrets.redactBody(contentType, body)),
+		}
+		if mt, _, _ := mime.ParseMediaType(contentType); mt == "application/x-www-form-urlencoded" {
+			if values, err := url.ParseQuery(string(body)); err == nil {
+				hr.PostData.Params = harValues(values, secrets)
+			}
+		}
+	}
+	return hr
+}
+
+func harResponse(resp *http.Response, body []byte, secrets DumpOptions) HARResponse {
+	hr := HARResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     harCookies(resp.Cookies()),
+		Headers:     harHeaders(resp.Header, secrets),
+		Content: HARContent{
+			Size:     int64(len(body)),
+			MimeType: resp.Header.Get("Content-Type"),
+		},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+	}
+	if utf8.Valid(body) {
+		hr.Content.Text = string(body)
+	} else {
+		hr.Content.Text = base64.StdEncoding.EncodeToString(body)
+		hr.Content.Encoding = "base64"
+	}
+	return hr
+}
+
+// harTransport records every round trip,
+// secrets are the API key names of the session's authenticator
+type harTransport struct {
+	base     http.RoundTripper
+	recorder *HARRecorder
+	secrets  []string
+}
+
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := peekRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := newTimer()
+	r := req.WithContext(httptrace.WithClientTrace(req.Context(), timer.trace()))
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	timer.Lock()
+	timer.done = time.Now()
+	timer.Unlock()
+
+	secrets := DumpOptions{Redact: t.recorder.secrets(t.secrets)}
+	e := &HAREntry{
+		StartedDateTime: timer.start.Format(time.RFC3339Nano),
+		Time:            millis(timer.start, timer.done),
+		Request:         harRequest(req, body, secrets),
+		Response:        harResponse(resp, data, secrets),
+		Timings:         timer.har(),
+	}
+	if timer.remoteAddr != nil {
+		host, port, err := net.SplitHostPort(timer.remoteAddr.String())
+		if err == nil {
+			e.ServerIPAddress, e.Connection = host, port
+		}
+	}
+	t.recorder.add(e)
+	return resp, nil
+}
+
+// RecordHAR records every exchange into the recorder,
+// responses served by the cache are not recorded, pass nil to disable it
+func (s *Session) RecordHAR(recorder *HARRecorder) {
+	s.Lock()
+	defer s.Unlock()
+	s.har = recorder
+}
+
+// LoadHAR reads a HAR file, e.g. exported by browser devtools
+func LoadHAR(path string) (*HAR, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	har := &HAR{}
+	err = json.Unmarshal(data, har)
+	if err != nil {
+		return nil, err
+	}
+	return har, nil
+}
+
+// header fields which are handled by net/http, or HTTP/2 pseudo headers
+var harSkipHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Accept-Encoding":   true,
+	"Transfer-Encoding": true,
+	"Cookie":            true,
+}
+
+// H rebuilds the request as nic.H, cookies are kept in H.Cookies
+// if keepCookies is true, redirection is disabled because
+// every hop is an entry of HAR
+func (r HARRequest) H(keepCookies bool) (method, urlStr string, h H) {
+	h = H{
+		Headers: KV{},
+	}
+	for _, kv := range r.Headers {
+		name := http.CanonicalHeaderKey(kv.Name)
+		// values redacted by the recorder are useless to send
+		if strings.HasPrefix(kv.Name, ":") || harSkipHeaders[name] || kv.Value == redacted {
+			continue
+		}
+		h.Headers[name] = kv.Value
+	}
+
+	if keepCookies && len(r.Cookies) > 0 {
+		h.Cookies = KV{}
+		for _, c := range r.Cookies {
+			if c.Value != redacted {
+				h.Cookies[c.Name] = c.Value
+			}
+		}
+		if len(h.Cookies) == 0 {
+			h.Cookies = nil
+		}
+	}
+
+	if r.PostData != nil {
+		mt, _, _ := mime.ParseMediaType(r.PostData.MimeType)
+		if mt == "application/x-www-form-urlencoded" && r.PostData.Text == "" && len(r.PostData.Params) > 0 {
+			h.Data = KV{}
+			for _, p := range r.PostData.Params {
+				h.Data[p.Name] = p.Value
+			}
+		} else {
+			h.Raw = r.PostData.Text
+			if r.PostData.MimeType != "" {
+				h.Headers["Content-Type"] = r.PostData.MimeType
+			}
+		}
+	}
+
+	return r.Method, r.URL, h
+}
+
+// HARReplayOptions controls Session.ReplayHAR
+type HARReplayOptions struct {
+	// KeepCookies sends the recorded cookies,
+	// otherwise the session's cookie jar is used,
+	// cookies redacted by HARRecorder are never sent
+	KeepCookies bool
+
+	// RewriteHosts maps a recorded host to a new one,
+	// e.g. "www.example.com" => "localhost:8080" or "http://localhost:8080"
+	RewriteHosts map[string]string
+
+	// Include and Exclude filter entries by URL
+	Include *regexp.Regexp
+	Exclude *regexp.Regexp
+}
+
+func (o HARReplayOptions) rewrite(urlStr string) (string, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return "", err
+	}
+	to, ok := o.RewriteHosts[u.Host]
+	if !ok {
+		return urlStr, nil
+	}
+
+	if strings.Contains(to, "://") {
+		target, err := url.Parse(to)
+		if err != nil {
+			return "", err
+		}
+		u.Scheme, u.Host = target.Scheme, target.Host
+	} else {
+		u.Host = to
+	}
+	return u.String(), nil
+}
+
+// ReplayHAR sends the HAR entries in order, it stops at the first error
+// and returns the responses so far
+func (s *Session) ReplayHAR(har *HAR, opt HARReplayOptions) ([]*Response, error) {
+	responses := make([]*Response, 0, len(har.Log.Entries))
+	for _, e := range har.Log.Entries {
+		if opt.Include != nil && !opt.Include.MatchString(e.Request.URL) {
+			continue
+		}
+		if opt.Exclude != nil && opt.Exclude.MatchString(e.Request.URL) {
+			continue
+		}
+
+		method, urlStr, h := e.Request.H(opt.KeepCookies)
+		urlStr, err := opt.rewrite(urlStr)
+		if err != nil {
+			return responses, err
+		}
+
+		resp, err := s.Request(method, urlStr, h)
+		if err != nil {
+			return responses, err
+		}
+		responses = append(responses, resp)
+	}
+	return responses, nil
+}
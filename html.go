@@ -0,0 +1,218 @@
+package nic
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// Selection is a set of HTML nodes
+type Selection struct {
+	Nodes []*html.Node
+}
+
+// Document is a parsed HTML document
+type Document struct {
+	*Selection
+	Root *html.Node
+}
+
+// ParseHTML parses an utf-8 HTML document
+func ParseHTML(s string) (*Document, error) {
+	root, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return nil, err
+	}
+	return &Document{
+		Selection: &Selection{Nodes: []*html.Node{root}},
+		Root:      root,
+	}, nil
+}
+
+// Find returns the descendants matching the CSS selector in document order,
+// the selection is empty if the selector is invalid
+func (s *Selection) Find(selector string) *Selection {
+	sel, err := cascadia.Compile(selector)
+	if err != nil {
+		return &Selection{}
+	}
+
+	found := make([]*html.Node, 0)
+	seen := make(map[*html.Node]bool)
+	for _, n := range s.Nodes {
+		for _, m := range sel.MatchAll(n) {
+			if m != n && !seen[m] {
+				seen[m] = true
+				found = append(found, m)
+			}
+		}
+	}
+	return &Selection{Nodes: found}
+}
+
+// Length returns the count of nodes
+func (s *Selection) Length() int {
+	return len(s.Nodes)
+}
+
+// Eq returns the i-th node as a selection, empty if out of range
+func (s *Selection) Eq(i int) *Selection {
+	if i < 0 {
+		i += len(s.Nodes)
+	}
+	if i < 0 || i >= len(s.Nodes) {
+		return &Selection{}
+	}
+	return &Selection{Nodes: s.Nodes[i : i+1]}
+}
+
+// First returns the first node as a selection
+func (s *Selection) First() *Selection {
+	return s.Eq(0)
+}
+
+// Each calls fn for every node
+func (s *Selection) Each(fn func(i int, s *Selection)) *Selection {
+	for i, n := range s.Nodes {
+		fn(i, &Selection{Nodes: []*html.Node{n}})
+	}
+	return s
+}
+
+// Text returns the combined text of the nodes and their descendants
+func (s *Selection) Text() string {
+	b := &strings.Builder{}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range s.Nodes {
+		walk(n)
+	}
+	return b.String()
+}
+
+// Attr returns the attribute of the first node
+func (s *Selection) Attr(name string) (string, bool) {
+	if len(s.Nodes) == 0 {
+		return "", false
+	}
+	for _, a := range s.Nodes[0].Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, name) {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// HTML returns the inner HTML of the first node
+func (s *Selection) HTML() (string, error) {
+	if len(s.Nodes) == 0 {
+		return "", nil
+	}
+	b := &bytes.Buffer{}
+	for c := s.Nodes[0].FirstChild; c != nil; c = c.NextSibling {
+		err := html.Render(b, c)
+		if err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// HTML parses Response.Text as a HTML document,
+// it's decoded in the detected charset or the one set by SetEncode
+//
+//	doc, err := resp.HTML()
+//	doc.Find("ul.items > li a").Each(func(i int, s *nic.Selection) {
+//		href, _ := s.Attr("href")
+//		fmt.Println(s.Text(), href)
+//	})
+func (r *Response) HTML() (*Document, error) {
+	if r.doc != nil {
+		return r.doc, nil
+	}
+	doc, err := ParseHTML(r.Text)
+	if err != nil {
+		return nil, err
+	}
+	r.doc = doc
+	return doc, nil
+}
+
+// Title returns the trimmed text of `<title>`
+func (r *Response) Title() string {
+	doc, err := r.HTML()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(doc.Find("title").First().Text())
+}
+
+// Meta returns the content of `<meta>` tags keyed by
+// the lowercase name, property or http-equiv, e.g. "description", "og:title"
+func (r *Response) Meta() map[string]string {
+	meta := make(map[string]string)
+	doc, err := r.HTML()
+	if err != nil {
+		return meta
+	}
+
+	doc.Find("meta[content]").Each(func(i int, s *Selection) {
+		content, _ := s.Attr("content")
+		for _, attr := range []string{"name", "property", "http-equiv"} {
+			if key, ok := s.Attr(attr); ok && key != "" {
+				key = strings.ToLower(key)
+				if _, ok := meta[key]; !ok {
+					meta[key] = content
+				}
+				return
+			}
+		}
+	})
+	return meta
+}
+
+// Links returns the distinct absolute URLs of `<a href>` and `<area href>`,
+// resolved against `<base href>` and the final URL of the request
+func (r *Response) Links() []string {
+	links := make([]string, 0)
+	doc, err := r.HTML()
+	if err != nil {
+		return links
+	}
+
+	base := &url.URL{}
+	if r.Request != nil {
+		base = r.Request.URL
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			base = u
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, n := range doc.Find("a[href], area[href]").Nodes {
+		href, _ := (&Selection{Nodes: []*html.Node{n}}).Attr("href")
+		u, err := base.Parse(strings.TrimSpace(href))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		u.Fragment = ""
+		link := u.String()
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
@@ -0,0 +1,495 @@
+package nic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONPathError will be returned by Response.JSONPath
+// if the path is invalid or doesn't exist
+type JSONPathError struct {
+	Path string
+	Msg  string
+}
+
+func (e *JSONPathError) Error() string {
+	return fmt.Sprintf("nic: JSONPath %s: %s", e.Path, e.Msg)
+}
+
+// JSONResult is a value selected by Response.JSONPath,
+// the accessors return zero values if the type doesn't match
+type JSONResult struct {
+	// Value is one of nil, bool, json.Number, string,
+	// []interface{} and map[string]interface{}
+	Value interface{}
+}
+
+// String returns a string, or the JSON text of other types
+func (r JSONResult) String() string {
+	switch v := r.Value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	data, _ := json.Marshal(r.Value)
+	return string(data)
+}
+
+// Int returns a number or a numeric string as int64
+func (r JSONResult) Int() int64 {
+	switch v := r.Value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return int64(f)
+	case string:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	case bool:
+		if v {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Float returns a number or a numeric string as float64
+func (r JSONResult) Float() float64 {
+	switch v := r.Value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// Bool returns a bool, or whether a string is "true"
+func (r JSONResult) Bool() bool {
+	switch v := r.Value.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// Array returns the elements of an array
+func (r JSONResult) Array() []JSONResult {
+	v, ok := r.Value.([]interface{})
+	if !ok {
+		return nil
+	}
+	results := make([]JSONResult, len(v))
+	for i, e := range v {
+		results[i] = JSONResult{e}
+	}
+	return results
+}
+
+// Map returns the members of an object
+func (r JSONResult) Map() map[string]JSONResult {
+	v, ok := r.Value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	results := make(map[string]JSONResult, len(v))
+	for k, e := range v {
+		results[k] = JSONResult{e}
+	}
+	return results
+}
+
+// IsNull reports whether the value is JSON null
+func (r JSONResult) IsNull() bool {
+	return r.Value == nil
+}
+
+// jsonPathStep is a step of a JSONPath, e.g. `.name`, `[0]`, `[*]`, `..name`
+type jsonPathStep struct {
+	recursive bool
+	wildcard  bool
+	names     []string
+	indices   []int
+	slice     []*int
+}
+
+// multi reports whether the step could select more than one value
+func (s jsonPathStep) multi() bool {
+	return s.recursive || s.wildcard || s.slice != nil ||
+		len(s.names)+len(s.indices) > 1
+}
+
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	fail := func(msg string) ([]jsonPathStep, error) {
+		return nil, &JSONPathError{Path: path, Msg: msg}
+	}
+
+	p := strings.TrimSpace(path)
+	if !strings.HasPrefix(p, "$") {
+		return fail("must start with $")
+	}
+	p = p[1:]
+
+	steps := make([]jsonPathStep, 0)
+	for len(p) > 0 {
+		step := jsonPathStep{}
+		switch {
+		case strings.HasPrefix(p, ".."):
+			step.recursive = true
+			p = p[2:]
+			if strings.HasPrefix(p, "[") {
+				break
+			}
+			fallthrough
+		case p[0] == '.':
+			if !step.recursive {
+				p = p[1:]
+			}
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			name := p[:end]
+			p = p[end:]
+			switch name {
+			case "":
+				return fail("empty member name")
+			case "*":
+				step.wildcard = true
+			default:
+				step.names = []string{name}
+			}
+			steps = append(steps, step)
+			continue
+		case p[0] != '[':
+			return fail(fmt.Sprintf("unexpected %q", p[0]))
+		}
+
+		// bracket notation
+		end, err := closingBracket(p)
+		if err != nil {
+			return fail(err.Error())
+		}
+		inner := strings.TrimSpace(p[1:end])
+		p = p[end+1:]
+
+		switch {
+		case inner == "*":
+			step.wildcard = true
+		case strings.Contains(inner, ":") && !strings.ContainsAny(inner, `'"`):
+			parts := strings.Split(inner, ":")
+			if len(parts) > 3 {
+				return fail(fmt.Sprintf("invalid slice [%s]", inner))
+			}
+			step.slice = make([]*int, 3)
+			for i, part := range parts {
+				part = strings.TrimSpace(part)
+				if part == "" {
+					continue
+				}
+				n, err := strconv.Atoi(part)
+				if err != nil {
+					return fail(fmt.Sprintf("invalid slice [%s]", inner))
+				}
+				step.slice[i] = &n
+			}
+		default:
+			for _, part := range splitUnion(inner) {
+				part = strings.TrimSpace(part)
+				if len(part) >= 2 && (part[0] == '\'' || part[0] == '"') && part[len(part)-1] == part[0] {
+					step.names = append(step.names, part[1:len(part)-1])
+					continue
+				}
+				n, err := strconv.Atoi(part)
+				if err != nil {
+					return fail(fmt.Sprintf("invalid subscript [%s]", inner))
+				}
+				step.indices = append(step.indices, n)
+			}
+			if len(step.names) > 0 && len(step.indices) > 0 {
+				return fail(fmt.Sprintf("mixed names and indices [%s]", inner))
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// closingBracket returns the index of `]` which closes p[0], quotes are skipped
+func closingBracket(p string) (int, error) {
+	var quote byte
+	for i := 1; i < len(p); i++ {
+		switch {
+		case quote != 0:
+			if p[i] == quote {
+				quote = 0
+			}
+		case p[i] == '\'' || p[i] == '"':
+			quote = p[i]
+		case p[i] == ']':
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unclosed [")
+}
+
+// splitUnion splits `'a','b'` or `0,1` by commas out of quotes
+func splitUnion(s string) []string {
+	parts := make([]string, 0)
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case s[i] == ',':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// children returns the members or elements selected by the step on v
+func (s jsonPathStep) children(v interface{}) ([]interface{}, string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			values := make([]interface{}, 0, len(v))
+			for _, k := range keys {
+				values = append(values, v[k])
+			}
+			return values, ""
+		}
+		if len(s.names) == 0 {
+			return nil, "not an array"
+		}
+		values := make([]interface{}, 0, len(s.names))
+		for _, name := range s.names {
+			e, ok := v[name]
+			if !ok {
+				return nil, fmt.Sprintf("member %q not found", name)
+			}
+			values = append(values, e)
+		}
+		return values, ""
+
+	case []interface{}:
+		switch {
+		case s.wildcard:
+			return v, ""
+		case s.slice != nil:
+			return sliceArray(v, s.slice), ""
+		case len(s.indices) == 0:
+			return nil, "not an object"
+		}
+		values := make([]interface{}, 0, len(s.indices))
+		for _, i := range s.indices {
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return nil, fmt.Sprintf("index %d out of range (length %d)", i, len(v))
+			}
+			values = append(values, v[i])
+		}
+		return values, ""
+	}
+
+	if s.wildcard {
+		return nil, ""
+	}
+	return nil, "not an object or array"
+}
+
+func sliceArray(v []interface{}, slice []*int) []interface{} {
+	step := 1
+	if slice[2] != nil {
+		step = *slice[2]
+	}
+	if step == 0 {
+		return []interface{}{}
+	}
+	bound := func(p *int, def int) int {
+		if p == nil {
+			return def
+		}
+		n := *p
+		if n < 0 {
+			n += len(v)
+		}
+		if n < 0 {
+			n = -1
+			if step > 0 {
+				n = 0
+			}
+		}
+		if n > len(v) {
+			n = len(v)
+			if step < 0 {
+				n = len(v) - 1
+			}
+		}
+		return n
+	}
+
+	values := make([]interface{}, 0)
+	if step > 0 {
+		for i := bound(slice[0], 0); i < bound(slice[1], len(v)); i += step {
+			values = append(values, v[i])
+		}
+	} else {
+		for i := bound(slice[0], len(v)-1); i > bound(slice[1], -1); i += step {
+			values = append(values, v[i])
+		}
+	}
+	return values
+}
+
+// descendants returns v and all its descendants in document order
+func descendants(v interface{}) []interface{} {
+	values := []interface{}{v}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			values = append(values, descendants(v[k])...)
+		}
+	case []interface{}:
+		for _, e := range v {
+			values = append(values, descendants(e)...)
+		}
+	}
+	return values
+}
+
+// evalJSONPath evaluates the path on the decoded JSON,
+// a path which could select many values returns an array of them
+func evalJSONPath(root interface{}, path string) (JSONResult, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return JSONResult{}, err
+	}
+
+	current := []interface{}{root}
+	multi := false
+	for i, step := range steps {
+		multi = multi || step.multi()
+		next := make([]interface{}, 0)
+
+		if step.recursive {
+			// missing members are skipped while searching
+			for _, v := range current {
+				for _, d := range descendants(v) {
+					values, msg := step.children(d)
+					if msg == "" {
+						next = append(next, values...)
+					}
+				}
+			}
+			current = next
+			continue
+		}
+
+		for _, v := range current {
+			values, msg := step.children(v)
+			if msg != "" {
+				if multi {
+					// e.g. `$.items[*].id` skips items without id
+					continue
+				}
+				return JSONResult{}, &JSONPathError{
+					Path: path,
+					Msg:  msg + " at " + jsonPathPrefix(steps[:i+1]),
+				}
+			}
+			next = append(next, values...)
+		}
+		current = next
+	}
+
+	if multi {
+		return JSONResult{current}, nil
+	}
+	return JSONResult{current[0]}, nil
+}
+
+// jsonPathPrefix formats the steps back, for error messages
+func jsonPathPrefix(steps []jsonPathStep) string {
+	b := &strings.Builder{}
+	b.WriteString("$")
+	for _, s := range steps {
+		if s.recursive {
+			b.WriteString("..")
+		}
+		switch {
+		case s.wildcard:
+			b.WriteString("[*]")
+		case s.slice != nil:
+			b.WriteString("[:]")
+		case len(s.names) > 0:
+			b.WriteString("['" + strings.Join(s.names, "','") + "']")
+		default:
+			indices := make([]string, len(s.indices))
+			for i, n := range s.indices {
+				indices[i] = strconv.Itoa(n)
+			}
+			b.WriteString("[" + strings.Join(indices, ",") + "]")
+		}
+	}
+	return b.String()
+}
+
+// JSONPath selects a value of the JSON body, e.g.
+//
+//	id, err := resp.JSONPath("$.data.items[0].id")
+//	fmt.Println(id.Int())
+//	ids, err := resp.JSONPath("$.data.items[*].id")
+//	for _, id := range ids.Array() {
+//		fmt.Println(id.String())
+//	}
+//
+// member names, `['name']`, indices, negative indices, `[*]`, `.*`,
+// unions `[0,1]`, slices `[start:end:step]` and `..name` are supported,
+// paths with wildcards, unions, slices or `..` return an array of the matches,
+// other paths return a *JSONPathError if not found,
+// the decoded body is cached on the Response
+func (r *Response) JSONPath(path string) (JSONResult, error) {
+	if !r.jsonParsed {
+		decoder := json.NewDecoder(bytes.NewReader(r.Bytes))
+		decoder.UseNumber()
+		err := decoder.Decode(&r.jsonTree)
+		if err != nil {
+			return JSONResult{}, err
+		}
+		r.jsonParsed = true
+	}
+	return evalJSONPath(r.jsonTree, path)
+}
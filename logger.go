@@ -0,0 +1,178 @@
+package nic
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// LogOptions controls the events emitted by Session.SetLogger
+type LogOptions struct {
+	// Level is the level of request, response, redirect and retry events,
+	// slog.LevelInfo if nil
+	Level slog.Leveler
+
+	// ErrorLevel is the level of error events, slog.LevelError if nil
+	ErrorLevel slog.Leveler
+
+	// Headers adds request and response headers to the events
+	Headers bool
+
+	// Redact are extra secret names, matching header fields and query params
+	// are redacted, Authorization, Proxy-Authorization, cookie values
+	// and API keys of the authenticator are always redacted
+	Redact []string
+}
+
+func (o LogOptions) level() slog.Level {
+	if o.Level == nil {
+		return slog.LevelInfo
+	}
+	return o.Level.Level()
+}
+
+func (o LogOptions) errorLevel() slog.Level {
+	if o.ErrorLevel == nil {
+		return slog.LevelError
+	}
+	return o.ErrorLevel.Level()
+}
+
+func (o LogOptions) url(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	c := *u
+	c.RawQuery = DumpOptions{Redact: o.Redact}.redactPairs(u.RawQuery)
+	return c.String()
+}
+
+func (o LogOptions) headers(h http.Header) slog.Attr {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	dump := DumpOptions{Redact: o.Redact}
+	attrs := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		values := make([]string, len(h[k]))
+		for i, v := range h[k] {
+			values[i] = dump.redactHeader(k, v)
+		}
+		if len(values) == 1 {
+			attrs = append(attrs, slog.String(k, values[0]))
+		} else {
+			attrs = append(attrs, slog.Any(k, values))
+		}
+	}
+	return slog.Group("headers", attrs...)
+}
+
+// sessionLogger emits the events of Session.Request
+type sessionLogger struct {
+	logger *slog.Logger
+	opt    LogOptions
+}
+
+// options adds the API keys of the request's authenticator to Redact
+func (l *sessionLogger) options(req *http.Request) LogOptions {
+	opt := l.opt
+	if ex := exchangeOf(req); ex != nil && len(ex.secrets) > 0 {
+		opt.Redact = append(append([]string(nil), opt.Redact...), ex.secrets...)
+	}
+	return opt
+}
+
+func (l *sessionLogger) request(req *http.Request) {
+	opt := l.options(req)
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("url", opt.url(req.URL)),
+	}
+	if opt.Headers {
+		attrs = append(attrs, opt.headers(req.Header))
+	}
+	l.logger.LogAttrs(req.Context(), opt.level(), "nic request", attrs...)
+}
+
+func (l *sessionLogger) response(req *http.Request, resp *Response, d time.Duration) {
+	opt := l.options(req)
+	// the final URL if redirected
+	u := req.URL
+	if resp.Request != nil {
+		u = resp.Request.URL
+	}
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("url", opt.url(u)),
+		slog.Int("status", resp.StatusCode),
+		slog.Duration("duration", d),
+		slog.Int("bytes", len(resp.Bytes)),
+	}
+	if resp.Cache != CacheNone {
+		attrs = append(attrs, slog.String("cache", resp.Cache.String()))
+	}
+	if opt.Headers {
+		attrs = append(attrs, opt.headers(resp.Header))
+	}
+	l.logger.LogAttrs(req.Context(), opt.level(), "nic response", attrs...)
+}
+
+func (l *sessionLogger) error(req *http.Request, err error, d time.Duration) {
+	opt := l.options(req)
+	l.logger.LogAttrs(req.Context(), opt.errorLevel(), "nic error",
+		slog.String("method", req.Method),
+		slog.String("url", opt.url(req.URL)),
+		slog.Duration("duration", d),
+		slog.String("error", err.Error()),
+	)
+}
+
+func (l *sessionLogger) hop(req *http.Request, last *url.URL, lastStatus int, retry bool) {
+	opt := l.options(req)
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("url", opt.url(req.URL)),
+		slog.Int("previous_status", lastStatus),
+	}
+	msg := "nic retry"
+	if !retry {
+		msg = "nic redirect"
+		attrs = append(attrs, slog.String("from", opt.url(last)))
+	}
+	l.logger.LogAttrs(req.Context(), opt.level(), msg, attrs...)
+}
+
+// SetLogger emits structured events of requests, responses,
+// redirects, retries and errors by logger, pass nil to disable it
+func (s *Session) SetLogger(logger *slog.Logger, opt LogOptions) {
+	s.Lock()
+	defer s.Unlock()
+	if logger == nil {
+		s.logger = nil
+		return
+	}
+	s.logger = &sessionLogger{
+		logger: logger,
+		opt:    opt,
+	}
+}
+
+// logRequest logs the start of a request if a logger is set
+func (s *Session) logRequest(req *http.Request) {
+	if s.logger != nil {
+		s.logger.request(req)
+	}
+}
+
+// logError logs the error if a logger is set and returns it
+func (s *Session) logError(req *http.Request, err error, start time.Time) error {
+	if s.logger != nil && req != nil {
+		s.logger.error(req, err, time.Since(start))
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package nic
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsCollector receives the metrics of a session's traffic,
+// implement it to send them to other backends
+type MetricsCollector interface {
+	// RequestStarted is invoked before a request is sent
+	RequestStarted(method, host string)
+
+	// RequestFinished is invoked after the response body is read,
+	// status is 0 if the request failed
+	RequestFinished(method, host string, status int, d time.Duration, bytesOut, bytesIn int64)
+
+	// Retried is invoked when a request is sent again, e.g. digest auth
+	Retried(method, host string)
+}
+
+// DefaultBuckets are the default latency buckets in seconds, same as Prometheus's
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricKey struct {
+	method, host, status string
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// PrometheusMetrics is a MetricsCollector which exposes
+// the metrics in Prometheus text format as a http.Handler
+//
+//	metrics := nic.NewPrometheusMetrics("nic")
+//	session.SetMetrics(metrics)
+//	http.Handle("/metrics", metrics)
+type PrometheusMetrics struct {
+	namespace string
+	buckets   []float64
+
+	requests map[metricKey]uint64
+	latency  map[metricKey]*histogram
+	inFlight map[metricKey]int64
+	retries  map[metricKey]uint64
+	bytesOut map[metricKey]uint64
+	bytesIn  map[metricKey]uint64
+	sync.Mutex
+}
+
+// NewPrometheusMetrics returns a collector whose metric names
+// start with namespace, e.g. `nic_requests_total`,
+// buckets are DefaultBuckets if not given
+func NewPrometheusMetrics(namespace string, buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	return &PrometheusMetrics{
+		namespace: namespace,
+		buckets:   buckets,
+		requests:  make(map[metricKey]uint64),
+		latency:   make(map[metricKey]*histogram),
+		inFlight:  make(map[metricKey]int64),
+		retries:   make(map[metricKey]uint64),
+		bytesOut:  make(map[metricKey]uint64),
+		bytesIn:   make(map[metricKey]uint64),
+	}
+}
+
+// RequestStarted implements MetricsCollector
+func (m *PrometheusMetrics) RequestStarted(method, host string) {
+	m.Lock()
+	defer m.Unlock()
+	m.inFlight[metricKey{method: method, host: host}]++
+}
+
+// RequestFinished implements MetricsCollector
+func (m *PrometheusMetrics) RequestFinished(method, host string, status int, d time.Duration, bytesOut, bytesIn int64) {
+	m.Lock()
+	defer m.Unlock()
+
+	key := metricKey{method: method, host: host}
+	m.inFlight[key]--
+	m.bytesOut[key] += uint64(bytesOut)
+	m.bytesIn[key] += uint64(bytesIn)
+
+	h := m.latency[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.latency[key] = h
+	}
+	seconds := d.Seconds()
+	for i, le := range m.buckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+
+	key.status = "error"
+	if status != 0 {
+		key.status = strconv.Itoa(status)
+	}
+	m.requests[key]++
+}
+
+// Retried implements MetricsCollector
+func (m *PrometheusMetrics) Retried(method, host string) {
+	m.Lock()
+	defer m.Unlock()
+	m.retries[metricKey{method: method, host: host}]++
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func (k metricKey) labels(extra ...string) string {
+	pairs := []string{"method", k.method, "host", k.host}
+	if k.status != "" {
+		pairs = append(pairs, "status", k.status)
+	}
+	pairs = append(pairs, extra...)
+
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		labels = append(labels, pairs[i]+`="`+escapeLabel(pairs[i+1])+`"`)
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func sortedKeys(m interface{}) []metricKey {
+	keys := make([]metricKey, 0)
+	switch m := m.(type) {
+	case map[metricKey]uint64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[metricKey]int64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[metricKey]*histogram:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		if keys[i].host != keys[j].host {
+			return keys[i].host < keys[j].host
+		}
+		return keys[i].status < keys[j].status
+	})
+	return keys
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ServeHTTP writes the metrics in Prometheus text format
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteText(w)
+}
+
+// WriteText writes the metrics in Prometheus text format
+func (m *PrometheusMetrics) WriteText(w io.Writer) error {
+	m.Lock()
+	defer m.Unlock()
+
+	b := bufio.NewWriter(w)
+	name := func(s string) string {
+		if m.namespace == "" {
+			return s
+		}
+		return m.namespace + "_" + s
+	}
+	counter := func(metric, help string, values map[metricKey]uint64) {
+		metric = name(metric)
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", metric, help, metric)
+		for _, k := range sortedKeys(values) {
+			fmt.Fprintf(b, "%s%s %d\n", metric, k.labels(), values[k])
+		}
+	}
+
+	counter("requests_total", "Total number of finished requests.", m.requests)
+
+	metric := name("request_duration_seconds")
+	fmt.Fprintf(b, "# HELP %s Request latency in seconds.\n# TYPE %s histogram\n", metric, metric)
+	for _, k := range sortedKeys(m.latency) {
+		h := m.latency[k]
+		for i, le := range m.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", metric, k.labels("le", formatFloat(le)), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", metric, k.labels("le", "+Inf"), h.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", metric, k.labels(), formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", metric, k.labels(), h.count)
+	}
+
+	metric = name("requests_in_flight")
+	fmt.Fprintf(b, "# HELP %s Number of requests being sent.\n# TYPE %s gauge\n", metric, metric)
+	for _, k := range sortedKeys(m.inFlight) {
+		fmt.Fprintf(b, "%s%s %d\n", metric, k.labels(), m.inFlight[k])
+	}
+
+	counter("retries_total", "Total number of retried requests.", m.retries)
+	counter("request_bytes_total", "Total bytes of request bodies.", m.bytesOut)
+	counter("response_bytes_total", "Total bytes of response bodies.", m.bytesIn)
+	return b.Flush()
+}
+
+// SetMetrics reports the metrics of every request to collector,
+// pass nil to disable it
+func (s *Session) SetMetrics(collector MetricsCollector) {
+	s.Lock()
+	defer s.Unlock()
+	s.metrics = collector
+}
@@ -0,0 +1,203 @@
+package nic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// NetrcError is returned when the netrc file is malformed
+type NetrcError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *NetrcError) Error() string {
+	return fmt.Sprintf("nic: netrc %s:%d: %s", e.Path, e.Line, e.Msg)
+}
+
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+// netrc is an Authenticator which applies basic auth
+// from the matching `machine` entry
+type netrc struct {
+	machines map[string]*netrcEntry
+	def      *netrcEntry
+
+	// lookup results, nil means no entry
+	cache map[string]*netrcEntry
+	sync.Mutex
+}
+
+// netrcPath returns ~/.netrc, or ~/_netrc on windows
+func netrcPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	return filepath.Join(home, name), nil
+}
+
+func parseNetrc(path string, data []byte) (*netrc, error) {
+	n := &netrc{
+		machines: make(map[string]*netrcEntry),
+		cache:    make(map[string]*netrcEntry),
+	}
+
+	type token struct {
+		s    string
+		line int
+	}
+	tokens := make([]token, 0)
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		macdef := false
+		fields := strings.Fields(line)
+		for j := 0; j < len(fields) && !macdef; j++ {
+			f := fields[j]
+			if f == "macdef" {
+				// the rest of the line is the macro name
+				macdef = true
+				continue
+			}
+			if strings.HasPrefix(f, `"`) {
+				// quoted token may contain spaces
+				for !(len(f) > 1 && strings.HasSuffix(f, `"`)) && j+1 < len(fields) {
+					j++
+					f += " " + fields[j]
+				}
+				if len(f) < 2 || !strings.HasSuffix(f, `"`) {
+					return nil, &NetrcError{path, i + 1, "unterminated quoted token"}
+				}
+				f = f[1 : len(f)-1]
+			}
+			tokens = append(tokens, token{f, i + 1})
+		}
+
+		// macro definition lasts until an empty line
+		if macdef {
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+				i++
+			}
+		}
+	}
+
+	var cur *netrcEntry
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch t.s {
+		case "machine", "login", "password", "account":
+			if i+1 >= len(tokens) {
+				return nil, &NetrcError{path, t.line, fmt.Sprintf("missing value after %q", t.s)}
+			}
+			i++
+			v := tokens[i].s
+
+			switch t.s {
+			case "machine":
+				cur = &netrcEntry{}
+				host := strings.ToLower(v)
+				if _, ok := n.machines[host]; !ok {
+					// the first matching entry wins
+					n.machines[host] = cur
+				}
+			case "login", "password":
+				if cur == nil {
+					return nil, &NetrcError{path, t.line, fmt.Sprintf("%q before any machine", t.s)}
+				}
+				if t.s == "login" {
+					cur.login = v
+				} else {
+					cur.password = v
+				}
+			}
+
+		case "default":
+			cur = &netrcEntry{}
+			n.def = cur
+
+		default:
+			return nil, &NetrcError{path, t.line, fmt.Sprintf("unexpected token %q", t.s)}
+		}
+	}
+
+	return n, nil
+}
+
+func (n *netrc) lookup(host string) *netrcEntry {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	n.Lock()
+	defer n.Unlock()
+	if e, ok := n.cache[host]; ok {
+		return e
+	}
+	e, ok := n.machines[host]
+	if !ok {
+		e = n.def
+	}
+	n.cache[host] = e
+	return e
+}
+
+func (n *netrc) matchHost(host string) bool {
+	return n.lookup(host) != nil
+}
+
+func (n *netrc) Authenticate(req *http.Request) error {
+	e := n.lookup(req.URL.Host)
+	if e != nil {
+		req.SetBasicAuth(e.login, e.password)
+	}
+	return nil
+}
+
+// UseNetrc enables basic auth from the netrc file when H.Auth is not given,
+// an empty path means ~/.netrc, it returns *NetrcError if file is malformed
+func (s *Session) UseNetrc(path string) error {
+	var err error
+	if path == "" {
+		path, err = netrcPath()
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	n, err := parseNetrc(path, data)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.netrc = &scopedAuth{
+		auth: n,
+	}
+	return nil
+}
@@ -29,6 +29,10 @@ var (
 	// if encoding is not recognized
 	ErrUnrecognizedEncoding = errors.New("nic: Unrecognized encoding")
 
+	// ErrInvalidEncoded will be throwed while decoding response in strict mode
+	// if there are invalid bytes of the encoding
+	ErrInvalidEncoded = errors.New("nic: Invalid bytes of the encoding")
+
 	// ErrNotJsonResponse will be throwed when response not a json
 	// but invoke Json() method
 	ErrNotJsonResponse = errors.New("nic: Not a Json response")
@@ -39,6 +43,30 @@ var (
 
 	// ErrIndexOutofBound means the index out of bound
 	ErrIndexOutofBound = errors.New("nic: Index out of bound")
+
+	// ErrInvalidSignature will be throwed when a message signature
+	// is malformed or doesn't match
+	ErrInvalidSignature = errors.New("nic: Invalid message signature")
+
+	// ErrDigestMismatch will be throwed when `Content-Digest` doesn't match the body
+	ErrDigestMismatch = errors.New("nic: Content digest mismatch")
+
+	// ErrAuthNoHost will be throwed when a session authenticator
+	// is not scoped to any host
+	ErrAuthNoHost = errors.New("nic: Authenticator must be scoped to hosts")
+
+	// ErrJarNotSupported will be throwed when Session.Client.Jar
+	// is not a *nic.Jar but cookies are listed or persisted
+	ErrJarNotSupported = errors.New("nic: Cookie jar is not a *nic.Jar")
+
+	// ErrCurlSyntax will be throwed when a curl command line can't be parsed
+	ErrCurlSyntax = errors.New("nic: Invalid curl command")
+
+	// ErrNoRequest will be throwed when there is no request to export
+	ErrNoRequest = errors.New("nic: No request")
+
+	// ErrBodyCycle will be throwed when H.Body references itself
+	ErrBodyCycle = errors.New("nic: Body contains a reference cycle")
 )
 
 const (
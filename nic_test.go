@@ -1,6 +1,11 @@
 package nic
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/md5"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
@@ -9,12 +14,23 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 // Testing http server addr
@@ -263,7 +279,6 @@ func socks5start() {
 	ln, err := net.Listen("tcp", ":8088")
 	if err != nil {
 		panic(err)
-		return
 	}
 
 	for {
@@ -397,10 +412,201 @@ func init() {
 		}
 	})
 
+	http.HandleFunc("/sign", func(w http.ResponseWriter, r *http.Request) {
+		v := NewVerifier().HMACKey("nic-key", []byte("nic-secret"))
+		if err := v.VerifyRequest(r); err != nil {
+			fmt.Fprintf(w, "sign error")
+			return
+		}
+		fmt.Fprintf(w, "sign ok")
+	})
+
+	http.HandleFunc("/bearer", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, r.Header.Get("Authorization")+r.Header.Get("X-Api-Key"))
+	})
+
+	http.HandleFunc("/redirect-third", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost:2333/bearer", 302)
+	})
+
+	http.HandleFunc("/digest", func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			w.Header().Set("WWW-Authenticate", `Digest realm="nic", nonce="abc", qop="auth"`)
+			w.WriteHeader(401)
+			return
+		}
+		p := parseAuthParams(auth[7:])
+		h := func(s string) string {
+			return fmt.Sprintf("%x", md5.Sum([]byte(s)))
+		}
+		ha1 := h("nic:nic:nic")
+		ha2 := h(r.Method + ":" + p["uri"])
+		if p["response"] == h(ha1+":abc:"+p["nc"]+":"+p["cnonce"]+":auth:"+ha2) {
+			fmt.Fprintf(w, "digest ok")
+		} else {
+			w.WriteHeader(401)
+		}
+	})
+
+	http.HandleFunc("/gzip", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.WriteHeader(400)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte("gzip ok"))
+		zw.Close()
+	})
+
+	// redirects back to itself once
+	http.HandleFunc("/again", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("again"); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "again", Value: "1"})
+			http.Redirect(w, r, "/again", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "again ok")
+	})
+
+	var cacheHits int64
+	http.HandleFunc("/cache", func(w http.ResponseWriter, r *http.Request) {
+		hits := atomic.AddInt64(&cacheHits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "cache %d %s", hits, r.Header.Get("Authorization"))
+	})
+
+	http.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(304)
+			return
+		}
+		fmt.Fprintf(w, "etag")
+	})
+
+	http.HandleFunc("/charset", func(w http.ResponseWriter, r *http.Request) {
+		gbk, _ := simplifiedchinese.GBK.NewEncoder().String("你好，世界")
+		switch r.URL.Query().Get("by") {
+		case "header":
+			w.Header().Set("Content-Type", "text/html; charset=GBK")
+			fmt.Fprint(w, gbk)
+		case "meta":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head><meta http-equiv="Content-Type" content="text/html; charset=gb2312">`+gbk)
+		case "xml":
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="GBK"?><a>`+gbk+`</a>`)
+		case "bom":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "\xef\xbb\xbf你好，世界")
+		case "sniff-long":
+			// the sniffed prefix ends in the middle of a character
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "a"+strings.Repeat(gbk, 1000))
+		case "binary":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, gbk)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, gbk)
+		}
+	})
+
+	http.HandleFunc("/charset-post", func(w http.ResponseWriter, r *http.Request) {
+		decoder := simplifiedchinese.GBK.NewDecoder()
+		if strings.Contains(r.Header.Get("Content-Type"), "shift_jis") {
+			decoder = japanese.ShiftJIS.NewDecoder()
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			r.ParseMultipartForm(1 << 20)
+			for _, v := range r.MultipartForm.Value {
+				body = []byte(v[0])
+			}
+		} else if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			s, _ := url.QueryUnescape(string(body))
+			body = []byte(s)
+		}
+		query, _ := url.QueryUnescape(r.URL.RawQuery)
+		text, _ := decoder.String(query + " " + string(body))
+		fmt.Fprint(w, r.Header.Get("Content-Type")+" "+text)
+	})
+
+	http.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		page, _ := simplifiedchinese.GBK.NewEncoder().String(`<html><head>
+			<meta charset="gbk"><title> 你好 </title>
+			<meta name="Description" content="nic page">
+			<meta property="og:title" content="nic">
+			<base href="/docs/">
+		</head><body><ul class="items">
+			<li><a href="a.html#top">A</a></li>
+			<li><a href="https://example.com/b">B</a></li>
+			<li><a href="a.html">A again</a></li>
+			<li><a href="mailto:nic@example.com">mail</a></li>
+		</ul></body></html>`)
+		fmt.Fprint(w, page)
+	})
+
+	http.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?>
+<a:feed xmlns:a="http://www.w3.org/2005/Atom" xmlns:x="urn:x">
+	<a:entry x:id="1"><a:title>first</a:title></a:entry>
+	<a:entry x:id="2"><a:title>second</a:title></a:entry>
+</a:feed>`)
+	})
+
+	http.HandleFunc("/jsonpath", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data": {"total": 12345678901234567, "ok": true, "items": [
+			{"id": 1, "name": "a", "price": 1.5},
+			{"id": 2, "name": "b", "tags": ["x", "y"]},
+			{"id": 3, "name": "c", "my key": null}
+		]}}`)
+	})
+
+	http.HandleFunc("/decode", func(w http.ResponseWriter, r *http.Request) {
+		bodies := map[string]string{
+			"application/json; charset=utf-8":   `{"id": 7, "name": "a", "tags": ["x", "y"]}`,
+			"application/problem+json":          `{"id": 7, "name": "a", "tags": ["x", "y"]}`,
+			"application/xml":                   `<item><id>7</id><name>a</name><tags>x</tags><tags>y</tags></item>`,
+			"application/x-yaml":                "id: 7\nname: a\ntags: [x, y]\n",
+			"application/x-www-form-urlencoded": "id=7&name=a&tags=x&tags=y",
+			"application/msgpack": "\x85\xa2id\x07\xa4name\xa1a\xa4tags\x92\xa1x\xa1y" +
+				"\xa1n\xd1\xff\x38\xa2at\xd6\xff\x00\x00\x00\x01",
+			"text/plain": `{"id": 7, "name": "a", "tags": ["x", "y"]}`,
+		}
+		t := r.URL.Query().Get("type")
+		w.Header().Set("Content-Type", t)
+		fmt.Fprint(w, bodies[t])
+	})
+
+	http.HandleFunc("/body", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.Header().Set("X-Content-Length", fmt.Sprint(r.ContentLength))
+		w.Write(body)
+	})
+
+	http.HandleFunc("/trace", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, r.Header.Get("traceparent")+" "+r.Header.Get("tracestate"))
+	})
+
 	// run a socks5 server
 	// for proxy option testing
 	go socks5start()
-	go http.ListenAndServe(":2333", nil)
+
+	// listen before any test runs
+	ln, err := net.Listen("tcp", ":2333")
+	if err != nil {
+		panic(err)
+	}
+	go http.Serve(ln, nil)
 }
 
 // tesing via burpsuite proxy
@@ -598,3 +804,1339 @@ func TestHookFuncs(t *testing.T) {
 	}
 	t.Error("hook function error")
 }
+
+func TestMessageSignature(t *testing.T) {
+	session := NewSession()
+	session.SetSigner(HMACSigner("nic-key", []byte("nic-secret")))
+
+	resp, err := session.Post(baseURL+"/sign", H{
+		JSON: KV{
+			"nic": "nic",
+		},
+	})
+	if err != nil || resp.Text != "sign ok" {
+		t.Error("request signature error")
+		return
+	}
+
+	session.SetSigner(HMACSigner("nic-key", []byte("nic-secret")).
+		Cover("@method", "@target-uri", "@scheme", "@authority"))
+	resp, err = session.Get(baseURL+"/sign?nic=nic", nil)
+	if err != nil || resp.Text != "sign ok" {
+		t.Error("request signature target uri error")
+		return
+	}
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	r := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       ioutil.NopCloser(strings.NewReader("webhook")),
+	}
+	err = Ed25519Signer("hook-key", priv).
+		Cover("@status", "Content-Type", "Content-Digest").
+		SignResponse(r)
+	if err != nil || !strings.Contains(r.Header.Get("Signature-Input"), `"content-type" "content-digest"`) {
+		t.Error("response signature error")
+		return
+	}
+
+	v := NewVerifier().Ed25519Key("hook-key", pub)
+	if v.VerifyResponse(r) != nil {
+		t.Error("response signature verify error")
+		return
+	}
+	input := r.Header.Get("Signature-Input")
+	r.Header.Set("Signature-Input", strings.Replace(input, "content-type", "Content-Type", 1))
+	if v.VerifyResponse(r) != ErrInvalidSignature {
+		t.Error("response signature uppercase component error")
+		return
+	}
+	r.Header.Set("Signature-Input", input)
+	r.Body = ioutil.NopCloser(strings.NewReader("replayed"))
+	if v.VerifyResponse(r) != ErrDigestMismatch {
+		t.Error("response digest verify error")
+	} else {
+		t.Log("message signature ok ✔")
+	}
+}
+
+func TestAuthenticators(t *testing.T) {
+	session := NewSession()
+
+	resp, err := session.Get(baseURL+"/bearer", H{
+		Auth: BearerAuth("nic"),
+	})
+	if err != nil || resp.Text != "Bearer nic" {
+		t.Error("bearer auth error")
+		return
+	}
+
+	resp, err = session.Post(baseURL+"/digest", H{
+		Auth: DigestAuth("nic", "nic"),
+		Data: KV{
+			"nic": "nic",
+		},
+	})
+	if err != nil || resp.Text != "digest ok" {
+		t.Error("digest auth error")
+		return
+	}
+
+	_, err = session.Get(baseURL+"/bearer", H{
+		Auth: KV{
+			"a": "a",
+			"b": "b",
+		},
+	})
+	if err == nil {
+		t.Error("basic auth KV error")
+		return
+	}
+
+	if session.SetAuth(APIKeyHeader("X-Api-Key", "key")) != ErrAuthNoHost {
+		t.Error("session auth error")
+		return
+	}
+	session.SetAuth(APIKeyHeader("X-Api-Key", "key"), "127.0.0.1")
+	resp1, err := session.Get(baseURL+"/bearer", nil)
+	resp2, err := session.Get(baseURL+"/redirect-third", H{
+		AllowRedirect: true,
+	})
+	if err != nil || resp1.Text != "key" || resp2.Text != "" {
+		t.Error("session auth scope error")
+	} else {
+		t.Log("authenticators ok ✔")
+	}
+}
+
+func TestNetrc(t *testing.T) {
+	session := NewSession()
+
+	path := filepath.Join(t.TempDir(), ".netrc")
+	ioutil.WriteFile(path, []byte("machine example.com login a password b\nmachine"), 0600)
+	err := session.UseNetrc(path)
+	if _, ok := err.(*NetrcError); !ok {
+		t.Error("netrc parse error")
+		return
+	}
+
+	ioutil.WriteFile(path, []byte(`# nic
+machine example.com login a password b macdef "init a"
+cd /pub
+
+macdef "a b"
+echo nic
+
+machine 127.0.0.1
+	login nic
+	password nic
+`), 0600)
+	err = session.UseNetrc(path)
+	if err != nil {
+		t.Error("netrc error")
+		return
+	}
+
+	resp, err := session.Get(baseURL+"/auth", nil)
+	if err != nil || resp.Text != "auth ok" {
+		t.Error("netrc auth error")
+	} else {
+		t.Log("netrc ok ✔")
+	}
+}
+
+func TestPersistentCookies(t *testing.T) {
+	session := NewSession()
+	dir := t.TempDir()
+	auto := filepath.Join(dir, "auto.json")
+	session.AutoSaveCookies(auto)
+
+	session.Get(baseURL+"/cookie", nil)
+	for _, name := range []string{"cookies.json", "cookies.txt"} {
+		path := filepath.Join(dir, name)
+		err := session.SaveCookies(path)
+		if err != nil {
+			t.Error("save cookies error")
+			return
+		}
+
+		s := NewSession()
+		err = s.LoadCookies(path)
+		resp, _ := s.Get(baseURL+"/session", nil)
+		if err != nil || resp.Text != "session_keep_ok" {
+			t.Error("load cookies error: " + name)
+			return
+		}
+	}
+
+	s := NewSession()
+	err := s.LoadCookies(auto)
+	resp, _ := s.Get(baseURL+"/session", nil)
+	if err != nil || resp.Text != "session_keep_ok" {
+		t.Error("auto save cookies error")
+		return
+	}
+
+	// an empty path of a hand-edited cookies.txt means the root
+	jar := NewJar()
+	err = jar.ReadNetscape(strings.NewReader("127.0.0.1\tFALSE\t\tFALSE\t0\ta\tb\n"))
+	u, _ := url.Parse(baseURL + "/a/b")
+	if cookies := jar.Cookies(u); err != nil || len(cookies) != 1 || cookies[0].Value != "b" {
+		t.Error("load cookies empty path error")
+		return
+	}
+
+	// a failed save keeps the response
+	s.AutoSaveCookies(filepath.Join(dir, "missing", "auto.json"))
+	resp, err = s.Get(baseURL+"/session", nil)
+	if err == nil || resp == nil || resp.Text != "session_keep_ok" {
+		t.Error("auto save cookies failure error")
+	} else {
+		t.Log("persistent cookies ok ✔")
+	}
+}
+
+func TestCookieAPI(t *testing.T) {
+	session := NewSession()
+	session.Get(baseURL+"/cookie", nil)
+	session.SetCookie(baseURL+"/a/b", &http.Cookie{
+		Name:  "path",
+		Value: "1",
+		Path:  "/a",
+	})
+
+	cookies, err := session.Cookies(baseURL + "/a/c")
+	if err != nil || len(cookies) != 2 || cookies[0].Name != "path" || cookies[0].Domain != "127.0.0.1" {
+		t.Error("list cookies error")
+		return
+	}
+
+	clone := NewSession()
+	session.CloneCookies(clone)
+	session.DeleteCookie(baseURL, "nic")
+	count := 0
+	session.RangeCookies(func(c *http.Cookie) bool {
+		count++
+		return true
+	})
+	resp, _ := clone.Get(baseURL+"/session", nil)
+	if count != 1 || resp.Text != "session_keep_ok" {
+		t.Error("delete cookie error")
+		return
+	}
+
+	session.ClearCookies()
+	cookies, _ = session.Cookies(baseURL + "/a/c")
+	if len(cookies) != 0 {
+		t.Error("clear cookies error")
+	} else {
+		t.Log("cookie api ok ✔")
+	}
+}
+
+func TestBrowserCookies(t *testing.T) {
+	session := NewSession()
+
+	skipped, err := session.ImportCookies(strings.NewReader(`[
+		{"domain": "127.0.0.1", "hostOnly": true, "name": "nic", "path": "/",
+		 "sameSite": "lax", "session": true, "value": "nic"},
+		{"domain": ".com", "expirationDate": 4102444800.5, "name": "suffix",
+		 "path": "/", "session": false, "value": "1"},
+		{"domain": "localhost", "hostOnly": true, "name": "dev", "path": "/",
+		 "session": true, "value": "1"}
+	]`), EditThisCookie)
+	if err != nil || skipped != 1 {
+		t.Error("import cookies error")
+		return
+	}
+
+	resp, _ := session.Get(baseURL+"/session", nil)
+	if resp.Text != "session_keep_ok" {
+		t.Error("import cookies error")
+		return
+	}
+	if cookies, _ := session.Cookies("http://com/"); len(cookies) != 0 {
+		t.Error("import public suffix cookies error", cookies)
+		return
+	}
+	if cookies, _ := session.Cookies("http://localhost/"); len(cookies) != 1 || cookies[0].Name != "dev" {
+		t.Error("import localhost cookies error", cookies)
+		return
+	}
+	entries, _ := parseEditThisCookie(strings.NewReader(
+		`[{"domain": "a.com", "name": "a", "path": "/", "sameSite": "unspecified", "value": "1"}]`))
+	if len(entries) != 1 || entries[0].SameSite != 0 {
+		t.Error("import unspecified same site error")
+		return
+	}
+
+	buf := &strings.Builder{}
+	session.ExportCookies(buf, CookiesTxt)
+	clone := NewSession()
+	skipped, err = clone.ImportCookies(strings.NewReader(buf.String()), CookiesTxt)
+	cookies, _ := clone.Cookies(baseURL)
+	if err != nil || skipped != 0 || len(cookies) != 1 || cookies[0].Name != "nic" {
+		t.Error("export cookies error")
+	} else {
+		t.Log("browser cookies ok ✔")
+	}
+}
+
+func TestCache(t *testing.T) {
+	disk, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Error("disk cache error")
+		return
+	}
+
+	for _, storage := range []CacheStorage{NewMemoryCache(8), disk} {
+		session := NewSession()
+		session.SetCache(storage)
+
+		resp1, _ := session.Get(baseURL+"/cache", nil)
+		resp2, _ := session.Get(baseURL+"/cache", nil)
+		if resp1.Cache != CacheFetched || resp2.Cache != CacheHit || resp1.Text != resp2.Text {
+			t.Error("cache hit error")
+			return
+		}
+
+		resp1, _ = session.Get(baseURL+"/etag", nil)
+		resp2, _ = session.Get(baseURL+"/etag", nil)
+		if resp1.Cache != CacheFetched || resp2.Cache != CacheRevalidated || resp2.Text != "etag" {
+			t.Error("cache revalidate error")
+			return
+		}
+
+		resp1, _ = session.Get(baseURL+"/cache?miss", H{
+			Headers: KV{"Cache-Control": "only-if-cached"},
+		})
+		if resp1.Cache != CacheMiss || resp1.StatusCode != http.StatusGatewayTimeout {
+			t.Error("cache miss error")
+			return
+		}
+
+		session.SetAuth(BearerAuth("alice"), "127.0.0.1")
+		resp1, _ = session.Get(baseURL+"/cache", nil)
+		session.SetAuth(BearerAuth("bob"), "127.0.0.1")
+		resp2, _ = session.Get(baseURL+"/cache", nil)
+		if resp1.Cache != CacheFetched || resp2.Cache != CacheFetched ||
+			!strings.HasSuffix(resp2.Text, "Bearer bob") {
+			t.Error("cache auth error")
+			return
+		}
+		session.SetAuth(BearerAuth("alice"), "127.0.0.1")
+		resp2, _ = session.Get(baseURL+"/cache", nil)
+		if resp2.Cache != CacheHit || resp2.Text != resp1.Text {
+			t.Error("cache auth hit error")
+			return
+		}
+	}
+	t.Log("cache ok ✔")
+}
+
+func TestOffline(t *testing.T) {
+	session := NewSession()
+	session.SetCache(NewMemoryCache(0))
+	online, _ := session.Get(baseURL+"/etag", nil)
+
+	session.SetOffline(true)
+	offline, err := session.Get(baseURL+"/etag", nil)
+	if err != nil || offline.Cache != CacheHit || offline.Text != online.Text {
+		t.Error("offline hit error")
+		return
+	}
+
+	_, err = session.Post(baseURL+"/data", H{
+		Data: KV{
+			"nic": "nic",
+		},
+	})
+	var miss *ErrOfflineMiss
+	if !errors.As(err, &miss) || miss.Method != POST || miss.URL != baseURL+"/data" {
+		t.Error("offline miss error")
+	} else {
+		t.Log("offline ok ✔")
+	}
+}
+
+func TestCassette(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.yaml")
+	cassette, _ := LoadCassette(path)
+	cassette.MatchOn(MatchMethod, MatchURL, MatchBody)
+
+	session := NewSession()
+	session.UseCassette(cassette)
+	recorded, err := session.Post(baseURL+"/file", H{
+		Files: KV{
+			"file1": File("nic.go", []byte("package main")),
+			"file2": File("nic", []byte("package nic")),
+		},
+		Headers: KV{
+			"Authorization": "secret",
+		},
+	})
+	if err != nil || cassette.Save() != nil {
+		t.Error("cassette record error")
+		return
+	}
+
+	cassette, err = LoadCassette(path)
+	if err != nil || cassette.Mode != CassetteReplay ||
+		cassette.Interactions[0].Request.Headers.Get("Authorization") != "[REDACTED]" {
+		t.Error("cassette load error")
+		return
+	}
+	cassette.MatchOn(MatchMethod, MatchURL, MatchBody)
+
+	session = NewSession()
+	session.UseCassette(cassette)
+	replayed, err := session.Post(baseURL+"/file", H{
+		Files: KV{
+			"file1": File("nic.go", []byte("package main")),
+			"file2": File("nic", []byte("package nic")),
+		},
+	})
+	_, miss := session.Get(baseURL+"/not-recorded", nil)
+	if err != nil || replayed.Text != recorded.Text || miss == nil {
+		t.Error("cassette replay error")
+		return
+	}
+
+	// session cookies survive replaying by default
+	path = filepath.Join(t.TempDir(), "cookies.json")
+	cassette, _ = LoadCassette(path)
+	session = NewSession()
+	session.UseCassette(cassette)
+	session.Get(baseURL+"/cookie", nil)
+	if cassette.Save() != nil {
+		t.Error("cassette record cookies error")
+		return
+	}
+	cassette, _ = LoadCassette(path)
+	session = NewSession()
+	session.UseCassette(cassette)
+	_, err = session.Get(baseURL+"/cookie", nil)
+	cookies, _ := session.Cookies(baseURL)
+	if err != nil || len(cookies) != 1 || cookies[0].Name != "nic" || cookies[0].Value != "nic" {
+		t.Error("cassette replay cookies error", cookies, err)
+		return
+	}
+
+	// session cookies if asked and API keys never reach the disk
+	path = filepath.Join(t.TempDir(), "secrets.json")
+	cassette, _ = LoadCassette(path)
+	cassette.Redact("Cookie", "Set-Cookie")
+	session = NewSession()
+	session.SetAuth(APIKeyQuery("api_key", "secret-key"), "127.0.0.1:2333")
+	session.UseCassette(cassette)
+	_, err = session.Get(baseURL+"/cookie", nil)
+	if err != nil || cassette.Save() != nil {
+		t.Error("cassette record secrets error", err)
+		return
+	}
+	data, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(data), "secret-key") || strings.Contains(string(data), "nic=nic") ||
+		!strings.Contains(string(data), "api_key=%5BREDACTED%5D") {
+		t.Error("cassette redact secrets error", string(data))
+		return
+	}
+
+	cassette, _ = LoadCassette(path)
+	session.UseCassette(cassette)
+	if _, err := session.Get(baseURL+"/cookie", nil); err != nil {
+		t.Error("cassette replay redacted url error", err)
+	} else {
+		t.Log("cassette ok ✔")
+	}
+}
+
+func TestHAR(t *testing.T) {
+	session := NewSession()
+	recorder := NewHARRecorder()
+	session.RecordHAR(recorder)
+
+	session.Get(baseURL+"/redirect", H{
+		AllowRedirect: true,
+	})
+	session.Post(baseURL+"/data", H{
+		Data: KV{
+			"args1": "1",
+			"args2": "a&%%$$",
+		},
+	})
+
+	entries := recorder.HAR().Log.Entries
+	if len(entries) != 3 || entries[0].Response.RedirectURL != "/redirect-dst" ||
+		entries[1].Response.Content.Text != "redirect_ok" ||
+		len(entries[2].Request.PostData.Params) != 2 {
+		t.Error("har record error")
+		return
+	}
+
+	buf := &strings.Builder{}
+	stream, _ := NewHARStream(buf)
+	session.RecordHAR(stream)
+	session.Get(baseURL+"/get", nil)
+	session.Get(baseURL+"/get", nil)
+	stream.Close()
+
+	har := &HAR{}
+	err := json.Unmarshal([]byte(buf.String()), har)
+	if err != nil || len(har.Log.Entries) != 2 || har.Log.Entries[0].Timings.Wait < 0 {
+		t.Error("har stream error")
+		return
+	}
+
+	// secrets never reach the HAR file
+	recorder = NewHARRecorder().Redact("X-Token", "password")
+	session = NewSession()
+	session.SetAuth(APIKeyQuery("api_key", "secret-key"), "127.0.0.1:2333")
+	session.RecordHAR(recorder)
+	session.Get(baseURL+"/cookie", nil)
+	session.Get(baseURL+"/session", H{
+		Headers: KV{"Authorization": "secret-auth", "X-Token": "secret-token"},
+	})
+	session.Post(baseURL+"/data", H{
+		Data: KV{"password": "secret-password", "user": "alice"},
+	})
+	data, _ := json.Marshal(recorder.HAR())
+	entries = recorder.HAR().Log.Entries
+	if len(entries) != 3 || len(entries[2].Request.PostData.Params) != 2 ||
+		!strings.Contains(entries[2].Request.PostData.Text, "user=alice") || strings.Contains(string(data), "secret") ||
+		strings.Contains(string(data), `"value":"nic"`) || strings.Contains(string(data), "nic=nic") {
+		t.Error("har redact error", string(data))
+		return
+	}
+	if entries[1].Response.BodySize != int64(len("session_keep_ok")) {
+		t.Error("har body size error", entries[1].Response.BodySize)
+	} else {
+		t.Log("har ok ✔")
+	}
+}
+
+func TestReplayHAR(t *testing.T) {
+	har := &HAR{}
+	json.Unmarshal([]byte(`{"log": {"version": "1.2", "entries": [
+		{"request": {"method": "GET", "url": "http://example.com/get?nic=replay",
+			"headers": [{"name": ":authority", "value": "example.com"},
+				{"name": "X-Forwarded-For", "value": "2.2.2.2"},
+				{"name": "Authorization", "value": "[REDACTED]"}],
+			"cookies": [{"name": "c", "value": "har"}, {"name": "d", "value": "[REDACTED]"}]}},
+		{"request": {"method": "GET", "url": "http://example.com/static/a.js"}},
+		{"request": {"method": "POST", "url": "http://example.com/data",
+			"postData": {"mimeType": "application/x-www-form-urlencoded",
+				"params": [{"name": "args1", "value": "1"}, {"name": "args2", "value": "a&%%$$"}]}}}
+	]}}`), har)
+
+	// values redacted by HARRecorder are not replayed
+	_, _, h := har.Log.Entries[0].Request.H(true)
+	if _, ok := h.Headers["Authorization"]; ok || len(h.Cookies) != 1 {
+		t.Error("replay har redacted error", h)
+		return
+	}
+
+	session := NewSession()
+	responses, err := session.ReplayHAR(har, HARReplayOptions{
+		KeepCookies:  true,
+		RewriteHosts: map[string]string{"example.com": "127.0.0.1:2333"},
+		Exclude:      regexp.MustCompile(`\.js$`),
+	})
+	if err != nil || len(responses) != 2 ||
+		responses[0].Text != "ok2.2.2.2c=harreplay" || responses[1].Text != "post data ok" {
+		t.Error("replay har error")
+	} else {
+		t.Log("replay har ok ✔")
+	}
+}
+
+func TestCurl(t *testing.T) {
+	method, url, h, err := ParseCurl(`curl $'http://127.0.0.1:2333/data' \
+		-H 'X-Forwarded-For: 1.1.1.1' -b 'nic=nic; a=b' -sSL \
+		--data-raw 'args1=1' --data-urlencode "args2=a&%%\$\$"`)
+	if err != nil || method != POST || !h.AllowRedirect || h.Cookies["a"] != "b" {
+		t.Error("parse curl error")
+		return
+	}
+
+	session := NewSession()
+	resp, err := session.Request(method, url, h)
+	if err != nil || resp.Text != "post data ok" {
+		t.Error("parse curl request error")
+		return
+	}
+
+	cmd, err := resp.Curl()
+	if err != nil {
+		t.Error("export curl error", err)
+		return
+	}
+	method, url, h, err = ParseCurl(cmd)
+	resp, err = session.Request(method, url, h)
+	if err != nil || resp.Text != "post data ok" {
+		t.Error("export curl error")
+		return
+	}
+
+	_, _, h, err = ParseCurl(`curl --data-urlencode 'a=b c+d' http://127.0.0.1:2333/data`)
+	if err != nil || h.Raw != "a=b%20c%2Bd" {
+		t.Error("curl urlencode error", h.Raw)
+		return
+	}
+
+	method, url, h, err = ParseCurl(`curl 'http://127.0.0.1:2333/gzip' \
+		-H 'Accept-Encoding: gzip, deflate, br' --compressed`)
+	if err != nil {
+		t.Error("parse curl compressed error", err)
+		return
+	}
+	resp, err = session.Request(method, url, h)
+	if err != nil || resp.Text != "gzip ok" {
+		t.Error("curl compressed error")
+	} else {
+		t.Log("curl ok ✔")
+	}
+}
+
+func TestDump(t *testing.T) {
+	buf := &bytes.Buffer{}
+	session := NewSession()
+	session.SetDebug(buf, DumpOptions{Body: true, Redact: []string{"password"}})
+
+	resp, err := session.Post(baseURL+"/auth?token=1", H{
+		Auth:    BasicAuth("nic", "nic"),
+		Cookies: KV{"nic": "secret-cookie"},
+		Data:    KV{"user": "nic", "password": "secret-password"},
+	})
+	if err != nil || resp.Text != "auth ok" {
+		t.Error("dump request error")
+		return
+	}
+
+	debug := buf.String()
+	buf.Reset()
+	err = resp.Dump(buf, DumpOptions{Body: true, Redact: []string{"password"}})
+	for _, s := range []string{debug, buf.String()} {
+		if err != nil || strings.Contains(s, "secret") ||
+			!strings.Contains(s, "> POST /auth?token=1 HTTP/1.1") ||
+			!strings.Contains(s, "> Authorization: [REDACTED]") ||
+			!strings.Contains(s, "> Cookie: nic=[REDACTED]") ||
+			!strings.Contains(s, "password=%5BREDACTED%5D&user=nic") ||
+			!strings.Contains(s, "< HTTP/1.1 200 OK") ||
+			!strings.Contains(s, "auth ok") {
+			t.Error("dump error", s)
+			return
+		}
+	}
+	t.Log("dump ok ✔")
+}
+
+func TestTimings(t *testing.T) {
+	session := NewSession()
+	resp, err := session.Get(baseURL+"/redirect", H{AllowRedirect: true})
+	if err != nil || resp.Text != "redirect_ok" {
+		t.Error("timings request error")
+		return
+	}
+
+	tm := resp.Timings
+	if tm.RemoteAddr != "127.0.0.1:2333" || tm.Connect <= 0 ||
+		tm.FirstByte <= 0 || tm.Total < tm.FirstByte || tm.ConnReused {
+		t.Error("timings error", tm)
+		return
+	}
+
+	// keep-alive connections could be reused
+	session.RegisterBeforeReqHook(func(req *http.Request) error {
+		req.Close = false
+		return nil
+	})
+	session.Get(baseURL+"/get", nil)
+	resp, err = session.Get(baseURL+"/get", nil)
+	if err != nil || !resp.Timings.ConnReused || resp.Timings.Connect != 0 {
+		t.Error("timings reuse error", resp.Timings)
+	} else {
+		t.Log("timings ok ✔")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	session := NewSession()
+	session.SetLogger(slog.New(slog.NewJSONHandler(buf, nil)), LogOptions{
+		Headers: true,
+		Redact:  []string{"token"},
+	})
+
+	resp, err := session.Get(baseURL+"/redirect?token=secret", H{
+		AllowRedirect: true,
+		Auth:          BearerAuth("secret"),
+	})
+	if err != nil || resp.Text != "redirect_ok" {
+		t.Error("logger request error")
+		return
+	}
+	_, err = session.Get("http://127.0.0.1:1", nil)
+	if err == nil {
+		t.Error("logger error request should fail")
+		return
+	}
+
+	events := make([]map[string]interface{}, 0)
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		e := make(map[string]interface{})
+		decoder.Decode(&e)
+		events = append(events, e)
+	}
+
+	msgs := make([]string, 0)
+	for _, e := range events {
+		msgs = append(msgs, e["msg"].(string))
+	}
+	if strings.Join(msgs, ",") != "nic request,nic redirect,nic response,nic request,nic error" ||
+		events[2]["status"].(float64) != 200 || events[2]["bytes"].(float64) != 11 ||
+		events[4]["level"] != "ERROR" {
+		t.Error("logger events error", events)
+		return
+	}
+
+	dump, _ := json.Marshal(events)
+	if strings.Contains(string(dump), "secret") || !strings.Contains(string(dump), "REDACTED") {
+		t.Error("logger redact error", string(dump))
+		return
+	}
+
+	// API keys are applied outside the hop logger, yet redacted
+	for _, auth := range []Authenticator{APIKeyQuery("key", "secret"), APIKeyHeader("X-Key", "secret")} {
+		buf.Reset()
+		resp, err = session.Get(baseURL+"/redirect", H{
+			AllowRedirect: true,
+			Auth:          auth,
+		})
+		if err != nil || resp.Text != "redirect_ok" || strings.Contains(buf.String(), "secret") {
+			t.Error("logger api key redact error", buf.String())
+			return
+		}
+	}
+
+	// slog.LevelInfo is zero, yet it's a valid error level
+	buf.Reset()
+	session.SetLogger(slog.New(slog.NewJSONHandler(buf, nil)), LogOptions{
+		Level:      slog.LevelDebug,
+		ErrorLevel: slog.LevelInfo,
+	})
+	session.Get("http://127.0.0.1:1", nil)
+	if !strings.Contains(buf.String(), `"level":"INFO","msg":"nic error"`) ||
+		strings.Contains(buf.String(), "nic request") {
+		t.Error("logger levels error", buf.String())
+	} else {
+		t.Log("logger ok ✔")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics("nic", 0.5, 1)
+	session := NewSession()
+	session.SetMetrics(metrics)
+
+	session.Post(baseURL+"/digest", H{
+		Auth: DigestAuth("nic", "nic"),
+		Raw:  "12345",
+	})
+	session.Get("http://127.0.0.1:1", nil)
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest(GET, "/metrics", nil))
+	text := w.Body.String()
+	for _, line := range []string{
+		`# TYPE nic_requests_total counter`,
+		`nic_requests_total{method="POST",host="127.0.0.1:2333",status="200"} 1`,
+		`nic_requests_total{method="GET",host="127.0.0.1:1",status="error"} 1`,
+		`nic_request_duration_seconds_bucket{method="POST",host="127.0.0.1:2333",le="0.5"} 1`,
+		`nic_request_duration_seconds_bucket{method="POST",host="127.0.0.1:2333",le="+Inf"} 1`,
+		`nic_request_duration_seconds_count{method="POST",host="127.0.0.1:2333"} 1`,
+		`nic_requests_in_flight{method="POST",host="127.0.0.1:2333"} 0`,
+		`nic_retries_total{method="POST",host="127.0.0.1:2333"} 1`,
+		`nic_request_bytes_total{method="POST",host="127.0.0.1:2333"} 5`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Error("metrics error", line, text)
+			return
+		}
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Error("metrics content type error")
+	} else {
+		t.Log("metrics ok ✔")
+	}
+}
+
+type testSpan struct {
+	sc    SpanContext
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *testSpan) SpanContext() SpanContext { return s.sc }
+func (s *testSpan) RecordError(err error)    { s.err = err }
+func (s *testSpan) End()                     { s.ended = true }
+func (s *testSpan) SetAttributes(attrs map[string]interface{}) {
+	for k, v := range attrs {
+		s.attrs[k] = v
+	}
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, req *http.Request) (context.Context, Span) {
+	parent, _ := SpanContextFromContext(ctx)
+	span := &testSpan{sc: parent.Child(), attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return ContextWithSpanContext(ctx, span.sc), span
+}
+
+func TestTrace(t *testing.T) {
+	parent, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "k=v")
+	if err != nil || parent.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Error("parse traceparent error")
+		return
+	}
+	for _, invalid := range []string{"", "00-0-0-0", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		if _, err := ParseTraceparent(invalid, ""); err == nil {
+			t.Error("parse invalid traceparent error", invalid)
+			return
+		}
+	}
+	ctx := ContextWithSpanContext(context.Background(), parent)
+
+	// without a tracer the parent is propagated as is
+	session := NewSession()
+	resp, err := session.RequestContext(ctx, GET, baseURL+"/trace", nil)
+	if err != nil || resp.Text != parent.Traceparent()+" k=v" {
+		t.Error("propagate trace context error")
+		return
+	}
+
+	tracer := &testTracer{}
+	session.SetTracer(tracer)
+	resp, err = session.RequestContext(ctx, GET, baseURL+"/trace", nil)
+	span := tracer.spans[0]
+	if err != nil || resp.Text != span.sc.Traceparent()+" k=v" ||
+		span.sc.TraceID != parent.TraceID || span.sc.SpanID == parent.SpanID ||
+		!span.ended || span.attrs["http.response.status_code"] != 200 {
+		t.Error("client span error")
+		return
+	}
+
+	session.Post(baseURL+"/digest", H{Auth: DigestAuth("nic", "nic")})
+	session.Get("http://127.0.0.1:1", nil)
+	resp, err = session.Get(baseURL+"/again", H{AllowRedirect: true})
+	if err != nil || resp.Text != "again ok" || tracer.spans[3].attrs["nic.redirect_count"] != 1 ||
+		tracer.spans[3].attrs["http.request.resend_count"] != nil {
+		t.Error("client span redirect to itself error", tracer.spans[3].attrs)
+		return
+	}
+	if tracer.spans[1].attrs["http.request.resend_count"] != 1 ||
+		tracer.spans[2].err == nil || !tracer.spans[2].ended {
+		t.Error("client span annotation error")
+	} else {
+		t.Log("trace ok ✔")
+	}
+}
+
+func TestCharsetDetection(t *testing.T) {
+	for _, by := range []string{"header", "meta", "xml", "bom", "sniff", "sniff-long"} {
+		resp, err := Get(baseURL+"/charset?by="+by, nil)
+		if err != nil || !strings.HasSuffix(strings.TrimSuffix(resp.Text, "</a>"), "你好，世界") {
+			t.Error("charset detection error", by, resp.Text)
+			return
+		}
+		encode := "gbk"
+		if by == "bom" {
+			encode = "utf-8"
+		}
+		if resp.GetEncode() != encode || (by == "bom" && resp.Text != "你好，世界") {
+			t.Error("charset detection encoding error", by, resp.GetEncode())
+			return
+		}
+	}
+
+	// bodies of binary media types are not decoded
+	resp, err := Get(baseURL+"/charset?by=binary", nil)
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String("你好，世界")
+	if err != nil || resp.Text != gbk || resp.GetEncode() != "utf-8" {
+		t.Error("charset detection binary error", resp.GetEncode())
+		return
+	}
+	t.Log("charset detection ok ✔")
+}
+
+func TestSetEncode(t *testing.T) {
+	resp, err := Get(baseURL+"/charset?by=header", nil)
+	if err != nil || resp.Text != "你好，世界" {
+		t.Error("set encode request error")
+		return
+	}
+
+	// decoding always starts from the raw bytes
+	for i := 0; i < 2; i++ {
+		err = resp.SetEncode("latin1")
+		if err != nil || resp.GetEncode() != "windows-1252" || resp.Text == "你好，世界" {
+			t.Error("set encode error", resp.GetEncode())
+			return
+		}
+	}
+	err = resp.SetEncode("GB2312")
+	if err != nil || resp.GetEncode() != "gbk" || resp.Text != "你好，世界" {
+		t.Error("set encode again error")
+		return
+	}
+
+	err = resp.SetEncode("utf-8", DecodeStrict)
+	if err != ErrInvalidEncoded || resp.GetEncode() != "gbk" || resp.Text != "你好，世界" {
+		t.Error("set encode strict error")
+		return
+	}
+	err = resp.SetEncode("utf-8")
+	if err != nil || !strings.Contains(resp.Text, "\ufffd") {
+		t.Error("set encode replace error")
+		return
+	}
+
+	err = resp.SetEncode("not-an-encoding")
+	if err != ErrUnrecognizedEncoding || resp.GetEncode() != "utf-8" {
+		t.Error("set encode unrecognized error")
+	} else {
+		t.Log("set encode ok ✔")
+	}
+}
+
+func TestRequestCharset(t *testing.T) {
+	resp, err := Post(baseURL+"/charset-post", H{
+		Params:  KV{"查询": "你好"},
+		Data:    KV{"名字": "世界"},
+		Charset: "gb2312",
+	})
+	if err != nil || resp.Text != "application/x-www-form-urlencoded; charset=gbk 查询=你好 名字=世界" {
+		t.Error("request charset data error", resp.Text)
+		return
+	}
+
+	resp, err = Post(baseURL+"/charset-post", H{
+		Files:   KV{"名字": "世界"},
+		Charset: "gbk",
+	})
+	if err != nil || !strings.HasSuffix(resp.Text, " 世界") {
+		t.Error("request charset files error", resp.Text)
+		return
+	}
+
+	resp, err = Post(baseURL+"/charset-post", H{
+		Raw:     "こんにちは",
+		Headers: KV{"Content-Type": "text/plain; charset=utf-8"},
+		Charset: "sjis",
+	})
+	if err != nil || resp.Text != "text/plain; charset=shift_jis  こんにちは" {
+		t.Error("request charset raw error", resp.Text)
+		return
+	}
+
+	_, err = Post(baseURL+"/charset-post", H{Raw: "😀", Charset: "gbk"})
+	if err == nil {
+		t.Error("request charset unencodable error")
+	} else {
+		t.Log("request charset ok ✔")
+	}
+}
+
+func TestHTML(t *testing.T) {
+	resp, err := Get(baseURL+"/html", nil)
+	if err != nil {
+		t.Error("html request error")
+		return
+	}
+
+	doc, err := resp.HTML()
+	if err != nil || doc.Find("ul.items li").Length() != 4 {
+		t.Error("html find error")
+		return
+	}
+	texts := make([]string, 0)
+	doc.Find("ul.items").Find("a").Each(func(i int, s *Selection) {
+		texts = append(texts, s.Text())
+	})
+	href, ok := doc.Find("li a").Eq(1).Attr("href")
+	if strings.Join(texts, ",") != "A,B,A again,mail" || !ok || href != "https://example.com/b" {
+		t.Error("html selection error", texts, href)
+		return
+	}
+	if doc.Find("!invalid").Length() != 0 {
+		t.Error("html invalid selector error")
+		return
+	}
+
+	meta := resp.Meta()
+	links := resp.Links()
+	if resp.Title() != "你好" || meta["description"] != "nic page" || meta["og:title"] != "nic" ||
+		strings.Join(links, " ") != baseURL+"/docs/a.html https://example.com/b" {
+		t.Error("html helpers error", resp.Title(), meta, links)
+	} else {
+		t.Log("html ok ✔")
+	}
+}
+
+func TestXPath(t *testing.T) {
+	resp, err := Get(baseURL+"/html", nil)
+	if err != nil {
+		t.Error("xpath request error")
+		return
+	}
+	v, err := resp.XPath(`//ul[@class="items"]/li/a/@href`)
+	nodes, ok := v.([]*XPathNode)
+	if err != nil || !ok || len(nodes) != 4 || nodes[1].Name != "href" ||
+		nodes[1].Value != "https://example.com/b" || nodes[1].HTML.Data != "a" {
+		t.Error("xpath html nodes error", v, err)
+		return
+	}
+	doc, _ := resp.HTML()
+	if v, err := resp.XPath(`count(//li)`); err != nil || v != float64(4) {
+		t.Error("xpath number error", v, err)
+		return
+	}
+	if v, err := resp.XPath(`string(//title)`); err != nil || v != " 你好 " {
+		t.Error("xpath string error", v, err)
+		return
+	}
+	if doc2, _ := resp.HTML(); doc2 != doc {
+		t.Error("xpath html cache error")
+		return
+	}
+	if _, err := resp.XPath(`//li[`); err == nil {
+		t.Error("xpath invalid expression error")
+		return
+	}
+
+	resp, err = Get(baseURL+"/feed", nil)
+	ns := map[string]string{"atom": "http://www.w3.org/2005/Atom", "ext": "urn:x"}
+	v, err = resp.XPath(`//atom:entry[@ext:id="2"]/atom:title`, ns)
+	nodes, ok = v.([]*XPathNode)
+	if err != nil || !ok || len(nodes) != 1 || nodes[0].Value != "second" ||
+		nodes[0].XML.Name.Space != "http://www.w3.org/2005/Atom" {
+		t.Error("xpath xml namespace error", v, err)
+		return
+	}
+	if v, err := resp.XPath(`count(//atom:title)`); err != nil || v != float64(0) {
+		t.Error("xpath xml unbound prefix error", v, err)
+	} else {
+		t.Log("xpath ok ✔")
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	resp, err := Get(baseURL+"/jsonpath", nil)
+	if err != nil {
+		t.Error("jsonpath request error")
+		return
+	}
+	v, err := resp.JSONPath("$.data.total")
+	if err != nil || v.Int() != 12345678901234567 || v.String() != "12345678901234567" {
+		t.Error("jsonpath int error", v, err)
+		return
+	}
+	if v, err := resp.JSONPath("$['data'].ok"); err != nil || !v.Bool() {
+		t.Error("jsonpath bool error", v, err)
+		return
+	}
+	if v, err := resp.JSONPath("$.data.items[0].price"); err != nil || v.Float() != 1.5 {
+		t.Error("jsonpath float error", v, err)
+		return
+	}
+	if v, err := resp.JSONPath("$.data.items[-1]['my key']"); err != nil || !v.IsNull() {
+		t.Error("jsonpath null error", v, err)
+		return
+	}
+	if v, err := resp.JSONPath("$.data.items[1]"); err != nil || v.Map()["name"].String() != "b" ||
+		v.Map()["tags"].String() != `["x","y"]` {
+		t.Error("jsonpath object error", v, err)
+		return
+	}
+
+	ids := []int64{}
+	v, err = resp.JSONPath("$.data.items[*].id")
+	for _, id := range v.Array() {
+		ids = append(ids, id.Int())
+	}
+	if err != nil || fmt.Sprint(ids) != "[1 2 3]" {
+		t.Error("jsonpath wildcard error", v, err)
+		return
+	}
+	if v, err := resp.JSONPath("$..tags[*]"); err != nil || v.String() != `["x","y"]` {
+		t.Error("jsonpath recursive error", v, err)
+		return
+	}
+	if v, err := resp.JSONPath("$.data.items[1:].name"); err != nil || v.String() != `["b","c"]` {
+		t.Error("jsonpath slice error", v, err)
+		return
+	}
+	if v, err := resp.JSONPath("$.data.items[*].price"); err != nil || len(v.Array()) != 1 {
+		t.Error("jsonpath missing in wildcard error", v, err)
+		return
+	}
+
+	_, err = resp.JSONPath("$.data.items[5].id")
+	if e, ok := err.(*JSONPathError); !ok || e.Path != "$.data.items[5].id" ||
+		!strings.Contains(e.Msg, "index 5 out of range (length 3) at $['data']['items'][5]") {
+		t.Error("jsonpath index not found error", err)
+		return
+	}
+	if _, err := resp.JSONPath("$.data.count"); err == nil || !strings.Contains(err.Error(), `member "count" not found`) {
+		t.Error("jsonpath member not found error", err)
+		return
+	}
+	if _, err := resp.JSONPath("data[0"); err == nil {
+		t.Error("jsonpath syntax error")
+		return
+	}
+
+	resp, _ = Get(baseURL+"/html", nil)
+	if _, err := resp.JSONPath("$"); err == nil {
+		t.Error("jsonpath invalid json error")
+	} else {
+		t.Log("jsonpath ok ✔")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type item struct {
+		ID   int      `xml:"id" form:"id" msgpack:"id"`
+		Name string   `xml:"name"`
+		Tags []string `xml:"tags"`
+		N    int16
+		At   time.Time
+	}
+
+	types := []string{
+		"application/json; charset=utf-8",
+		"application/problem+json",
+		"application/xml",
+		"application/x-yaml",
+		"application/x-www-form-urlencoded",
+		"application/msgpack",
+		"text/plain",
+	}
+	for _, typ := range types {
+		resp, err := Get(baseURL+"/decode", H{Params: KV{"type": typ}})
+		if err != nil {
+			t.Error("decode request error", err)
+			return
+		}
+		v := &item{}
+		err = resp.Decode(v)
+		if err != nil || v.ID != 7 || v.Name != "a" || fmt.Sprint(v.Tags) != "[x y]" {
+			t.Error("decode error", typ, v, err)
+			return
+		}
+		if typ == "application/msgpack" && (v.N != -200 || !v.At.Equal(time.Unix(1, 0))) {
+			t.Error("decode msgpack error", v)
+			return
+		}
+	}
+
+	// a hostile body nesting arrays deeper than the limit
+	deep := append(bytes.Repeat([]byte{0x91}, 1000000), 0xc0)
+	var deepV interface{}
+	if err := unmarshalMsgpack(deep, &deepV); err == nil || !strings.Contains(err.Error(), "depth") {
+		t.Error("decode msgpack depth error", err)
+		return
+	}
+	nested := append(bytes.Repeat([]byte{0x91}, codecMaxDepth-1), 0xc0)
+	if err := unmarshalMsgpack(nested, &deepV); err != nil {
+		t.Error("decode msgpack nested error", err)
+		return
+	}
+
+	// malformed bodies are errors, not panics
+	for name, err := range map[string]error{
+		"msgpack truncated":  unmarshalMsgpack([]byte{0x92, 0x01}, &deepV),
+		"cbor truncated":     unmarshalCBOR([]byte{0x82, 0x01}, &deepV),
+		"protobuf malformed": unmarshalProtobuf([]byte{0xff}, &wrapperspb.StringValue{}),
+		"protobuf target":    unmarshalProtobuf(nil, &deepV),
+	} {
+		if err == nil {
+			t.Error("decode " + name + " error")
+			return
+		}
+	}
+
+	resp, _ := Get(baseURL+"/decode", H{Params: KV{"type": "text/plain"}})
+	if err, ok := resp.Decode(&item{}, DecodeStrict).(*MediaTypeError); !ok || err.MediaType != "text/plain" {
+		t.Error("decode strict error", err)
+		return
+	}
+	if err := resp.JSON(&item{}, DecodeStrict); err != ErrNotJsonResponse {
+		t.Error("json strict error", err)
+		return
+	}
+
+	RegisterDecoder("text/plain", func(data []byte, v interface{}) error {
+		*(v.(*string)) = strings.ToUpper(string(data))
+		return nil
+	})
+	defer RegisterDecoder("text/plain", nil)
+	s := ""
+	if err := resp.Decode(&s, DecodeStrict); err != nil || !strings.HasPrefix(s, `{"ID"`) {
+		t.Error("decode registered error", s, err)
+	} else {
+		t.Log("decode ok ✔")
+	}
+}
+
+// orderedReader records whether it's read before the request headers are written
+type orderedReader struct {
+	r     io.Reader
+	wrote *bool
+	early bool
+}
+
+func (r *orderedReader) Read(p []byte) (int, error) {
+	if !*r.wrote {
+		r.early = true
+	}
+	return r.r.Read(p)
+}
+
+func TestBody(t *testing.T) {
+	type item struct {
+		ID   int      `json:"id" xml:"id" yaml:"id"`
+		Name string   `json:"name" xml:"name" yaml:"name"`
+		Tags []string `json:"tags" xml:"tags" yaml:"tags"`
+		At   time.Time
+	}
+	sent := item{ID: 7, Name: "你好", Tags: []string{"x", "y"}, At: time.Unix(1, 500).UTC()}
+
+	types := []string{
+		"",
+		"application/vnd.api+json",
+		"application/xml",
+		"application/x-yaml",
+		"application/msgpack",
+		"application/cbor",
+	}
+	for _, typ := range types {
+		resp, err := Post(baseURL+"/body", H{Body: sent, ContentType: typ})
+		if err != nil {
+			t.Error("body request error", typ, err)
+			return
+		}
+		got := item{}
+		err = resp.Decode(&got, DecodeStrict)
+		if err != nil || got.ID != 7 || got.Name != "你好" || fmt.Sprint(got.Tags) != "[x y]" {
+			t.Error("body round trip error", typ, got, err)
+			return
+		}
+		if typ == "application/msgpack" && !got.At.Equal(sent.At) ||
+			typ == "application/cbor" && !got.At.Equal(sent.At.Round(time.Microsecond)) {
+			t.Error("body binary time error", typ, got.At)
+			return
+		}
+	}
+
+	resp, err := Post(baseURL+"/body", H{
+		Body:        wrapperspb.String("你好"),
+		ContentType: "application/x-protobuf",
+	})
+	pb := &wrapperspb.StringValue{}
+	if err != nil || resp.Decode(pb) != nil || pb.Value != "你好" {
+		t.Error("body protobuf error", pb, err)
+		return
+	}
+	if _, err := Post(baseURL+"/body", H{Body: sent, ContentType: "application/x-protobuf"}); err == nil {
+		t.Error("body protobuf non message error")
+		return
+	}
+
+	// cycles are reported instead of recursing until the stack overflows
+	cycle := map[string]interface{}{}
+	cycle["self"] = cycle
+	type node struct{ Next *node }
+	loop := &node{}
+	loop.Next = loop
+	for _, typ := range []string{"application/msgpack", "application/cbor", "application/x-yaml"} {
+		for _, body := range []interface{}{cycle, loop} {
+			if _, err := Post(baseURL+"/body", H{Body: body, ContentType: typ}); err != ErrBodyCycle {
+				t.Error("body cycle error", typ)
+				return
+			}
+		}
+	}
+	shared := &node{}
+	if _, err := Post(baseURL+"/body", H{Body: []*node{shared, shared}, ContentType: "application/msgpack"}); err != nil {
+		t.Error("body shared reference error", err)
+		return
+	}
+
+	resp, err = Post(baseURL+"/body", H{
+		Body:        map[string]string{"b": "2", "a": "你"},
+		ContentType: "application/x-www-form-urlencoded",
+		Charset:     "gbk",
+	})
+	if err != nil || resp.Text != "a=%C4%E3&b=2" ||
+		resp.Header.Get("Content-Type") != "application/x-www-form-urlencoded; charset=gbk" {
+		t.Error("body form error", resp.Text, err)
+		return
+	}
+
+	resp, err = Post(baseURL+"/body", H{BodyReader: strings.NewReader("stream"), ContentType: "text/plain"})
+	if err != nil || resp.Text != "stream" || resp.Header.Get("X-Content-Length") != "6" {
+		t.Error("body reader error", resp.Text, err)
+		return
+	}
+
+	// the reader is first read by the transport after the headers are written
+	wrote := false
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		WroteHeaders: func() { wrote = true },
+	})
+	reader := &orderedReader{r: strings.NewReader("streamed"), wrote: &wrote}
+	resp, err = NewSession().RequestContext(ctx, POST, baseURL+"/body", H{BodyReader: reader})
+	if err != nil || resp.Text != "streamed" || reader.early {
+		t.Error("body reader streaming error", resp, err, reader.early)
+		return
+	}
+	resp, err = Post(baseURL+"/body", H{BodyReader: strings.NewReader("buffered"), BufferBody: true})
+	if cmd, _ := resp.Curl(); err != nil || !strings.Contains(cmd, "--data-binary 'buffered'") {
+		t.Error("body reader buffering error", cmd, err)
+		return
+	}
+
+	resp, err = Post(baseURL+"/body", H{Body: []byte{0, 1}, ContentType: "application/octet-stream"})
+	if err != nil || !bytes.Equal(resp.Bytes, []byte{0, 1}) {
+		t.Error("body bytes error", resp.Bytes, err)
+		return
+	}
+
+	if _, err := Post(baseURL+"/body", H{Body: sent, ContentType: "application/x-thrift"}); err == nil {
+		t.Error("body unsupported media type error")
+		return
+	}
+	RegisterEncoder("application/x-thrift", func(v interface{}) ([]byte, error) {
+		return []byte("thrift"), nil
+	})
+	defer RegisterEncoder("application/x-thrift", nil)
+	resp, err = Post(baseURL+"/body", H{Body: sent, ContentType: "application/x-thrift"})
+	if err != nil || resp.Text != "thrift" {
+		t.Error("body registered encoder error", resp.Text, err)
+		return
+	}
+
+	if _, err := Post(baseURL+"/body", H{Body: sent, Raw: "raw"}); err != ErrParamConflict {
+		t.Error("body conflict error", err)
+	} else {
+		t.Log("body ok ✔")
+	}
+}
@@ -3,16 +3,17 @@ package nic
 import (
 	"bytes"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -25,11 +26,28 @@ type (
 		Raw     string
 		Headers KV
 		Cookies KV
-		Auth    KV
+		Auth    Authenticator
 		Proxy   string
 		JSON    KV
 		Files   KV
 
+		// Body is encoded by the encoder registered for ContentType,
+		// JSON by default, a string or []byte is sent as is
+		Body interface{}
+		// BodyReader is streamed as the body, it's never read into memory
+		// unless BufferBody is set or a feature needs the bytes,
+		// i.e. SetDebug with the body, cassettes, HAR capture and signers
+		BodyReader io.Reader
+		// BufferBody reads BodyReader into memory before sending,
+		// so that it could be replayed, e.g. for digest auth and Session.Curl
+		BufferBody bool
+		// ContentType is the Content-Type of Body, BodyReader and Raw
+		ContentType string
+
+		// Charset encodes Params, Data, Raw, string Body and text fields of Files,
+		// e.g. "gbk", "shift_jis", JSON is always utf-8
+		Charset string
+
 		AllowRedirect      bool
 		Timeout            int64
 		Chunked            bool
@@ -113,6 +131,17 @@ func (f *F) MIME(mimetype string) *F {
 	return f
 }
 
+// keys returns the sorted keys, so that the encoded body is
+// the same every time, e.g. for cassette matching
+func (kv KV) keys() []string {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
 
 func escapeQuotes(s string) string {
@@ -123,11 +152,18 @@ func escapeQuotes(s string) string {
 type Option interface {
 	setRequestOpt(*http.Request) error
 	setClientOpt(*http.Client) error
+	authenticator() Authenticator
 }
 
-// could only contains one of Data, Raw, Files, Json
+// could only contains one of Data, Raw, Files, Json, Body, BodyReader
 func (h H) isConflict() bool {
 	count := 0
+	if h.Body != nil {
+		count++
+	}
+	if h.BodyReader != nil {
+		count++
+	}
 	if h.Data != nil {
 		count++
 	}
@@ -143,16 +179,25 @@ func (h H) isConflict() bool {
 	return count > 1
 }
 
-func setQuery(req *http.Request, p KV) error {
+func setQuery(req *http.Request, p KV, e textEncoder) error {
 	originURL := req.URL
 	extendQuery := make([]byte, 0)
 
-	for k, v := range p {
-		kEscaped := url.QueryEscape(k)
+	for _, k := range p.keys() {
+		v := p[k]
 		vs, ok := v.(string)
 		if !ok {
 			return fmt.Errorf("nic: query param %v[%T] must be string type", v, v)
 		}
+		k, err := e.encode(k)
+		if err != nil {
+			return err
+		}
+		vs, err = e.encode(vs)
+		if err != nil {
+			return err
+		}
+		kEscaped := url.QueryEscape(k)
 		vEscaped := url.QueryEscape(vs)
 
 		extendQuery = append(extendQuery, '&')
@@ -170,141 +215,268 @@ func setQuery(req *http.Request, p KV) error {
 	return nil
 }
 
-func setData(req *http.Request, d KV, chunked bool) error {
-	data := ""
-	for k, v := range d {
-		k = url.QueryEscape(k)
-
-		vs, ok := v.(string)
-		if !ok {
-			return fmt.Errorf(
-				"nic: post data %v[%T] must be string type", v, v)
+// encodeForm encodes KV, map[string]string, url.Values
+// or map[string][]string as a urlencoded form
+func encodeForm(d interface{}, e textEncoder) ([]byte, string, error) {
+	values := url.Values{}
+	switch d := d.(type) {
+	case KV:
+		for k, v := range d {
+			vs, ok := v.(string)
+			if !ok {
+				return nil, "", fmt.Errorf(
+					"nic: post data %v[%T] must be string type", v, v)
+			}
+			values.Set(k, vs)
+		}
+	case map[string]string:
+		for k, v := range d {
+			values.Set(k, v)
 		}
-		vs = url.QueryEscape(vs)
-		data = fmt.Sprintf("%s&%s=%s", data, k, vs)
+	case url.Values:
+		values = d
+	case map[string][]string:
+		values = d
+	default:
+		return nil, "", fmt.Errorf("nic: form data %T must be nic.KV or url.Values", d)
 	}
 
-	data = data[1:]
-	v := strings.NewReader(data)
-	req.Body = ioutil.NopCloser(v)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	if !chunked {
-		req.ContentLength = int64(v.Len())
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
-	return nil
+	data := &strings.Builder{}
+	for _, k := range keys {
+		ke, err := e.encode(k)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, vs := range values[k] {
+			vs, err = e.encode(vs)
+			if err != nil {
+				return nil, "", err
+			}
+			if data.Len() > 0 {
+				data.WriteByte('&')
+			}
+			data.WriteString(url.QueryEscape(ke) + "=" + url.QueryEscape(vs))
+		}
+	}
+	return []byte(data.String()), e.contentType("application/x-www-form-urlencoded"), nil
 }
 
-func setFiles(req *http.Request, files KV, chunked bool) error {
+// encodeMultipart encodes KV of *F and strings as a multipart form
+func encodeMultipart(v interface{}, e textEncoder) ([]byte, string, error) {
+	files, ok := v.(KV)
+	if !ok {
+		return nil, "", fmt.Errorf("nic: multipart form %T must be nic.KV", v)
+	}
 	buffer := &bytes.Buffer{}
 	writer := multipart.NewWriter(buffer)
 
-	for name, value := range files {
-		switch value := value.(type) {
+	for _, key := range files.keys() {
+		name, err := e.encode(key)
+		if err != nil {
+			return nil, "", err
+		}
+
+		switch value := files[key].(type) {
 		case *F:
 			mimetype := value.MimeType
 			if mimetype == "" {
 				mimetype = "application/octet-stream"
 			}
+			filename, err := e.encode(value.FileName)
+			if err != nil {
+				return nil, "", err
+			}
 
 			h := make(textproto.MIMEHeader)
 			h.Set("Content-Disposition",
 				fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
-					escapeQuotes(name), escapeQuotes(value.FileName)))
+					escapeQuotes(name), escapeQuotes(filename)))
 			h.Set("Content-Type", mimetype)
 
 			part, err := writer.CreatePart(h)
 			if err != nil {
-				return err
+				return nil, "", err
 			}
 
 			if len(value.Src) != 0 {
 				_, err = part.Write(value.Src)
 				if err != nil {
-					return err
+					return nil, "", err
 				}
 			} else {
 				fp, err := os.Open(value.FilePath)
 				if err != nil {
-					return err
+					return nil, "", err
 				}
 				defer fp.Close()
 
 				_, err = io.Copy(part, fp)
 				if err != nil {
-					return err
+					return nil, "", err
 				}
 			}
 
 		case string:
-			err := writer.WriteField(name, value)
+			value, err = e.encode(value)
+			if err != nil {
+				return nil, "", err
+			}
+			err = writer.WriteField(name, value)
 			if err != nil {
-				return err
+				return nil, "", err
 			}
 
 		default:
-			return ErrFileInfo
+			return nil, "", ErrFileInfo
 		}
 	}
 
 	err := writer.Close()
 	if err != nil {
-		return err
+		return nil, "", err
 	}
+	return buffer.Bytes(), writer.FormDataContentType(), nil
+}
 
-	req.Body = ioutil.NopCloser(buffer)
-	contentType := writer.FormDataContentType()
-	req.Header.Set("Content-Type", contentType)
-	if !chunked {
-		req.ContentLength = int64(buffer.Len())
+// body returns the request body and its media type,
+// Data, Raw, Files and JSON are shorthands of Body
+func (h H) body() (interface{}, string) {
+	switch {
+	case h.Data != nil:
+		return h.Data, "application/x-www-form-urlencoded"
+	case h.Raw != "":
+		return h.Raw, h.ContentType
+	case h.Files != nil:
+		return h.Files, "multipart/form-data"
+	case h.JSON != nil:
+		return h.JSON, "application/json"
+	case h.BodyReader != nil:
+		return h.BodyReader, h.ContentType
 	}
-	return nil
+	return h.Body, h.ContentType
 }
 
-func setJSON(req *http.Request, j KV, chunked bool) error {
-	jsonV, err := json.Marshal(j)
-	if err != nil {
-		return err
+// streamBody marks a body from a reader,
+// which Session never buffers so that it's streamed
+type streamBody struct {
+	io.ReadCloser
+}
+
+// setBody sets the body and its Content-Type,
+// a reader, a string or []byte is sent as is, a string is encoded in e,
+// other values are encoded by the encoder of the media type, JSON by default,
+// a reader is read into memory only if buffer is set
+func setBody(req *http.Request, body interface{}, mediaType string, chunked, buffer bool, e textEncoder) error {
+	var data []byte
+	contentType := mediaType
+
+	switch v := body.(type) {
+	case io.Reader:
+		if !buffer {
+			rc, ok := v.(io.ReadCloser)
+			if !ok {
+				rc = ioutil.NopCloser(v)
+			}
+			req.Body = &streamBody{rc}
+			if l, ok := v.(interface{ Len() int }); ok && !chunked {
+				req.ContentLength = int64(l.Len())
+			}
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			return nil
+		}
+		var err error
+		data, err = ioutil.ReadAll(v)
+		if c, ok := v.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return err
+		}
+
+	case string:
+		raw, err := e.encode(v)
+		if err != nil {
+			return err
+		}
+		data = []byte(raw)
+
+	case []byte:
+		data = v
+
+	default:
+		if mediaType == "" {
+			mediaType = "application/json"
+			contentType = mediaType
+		}
+		t, _, err := mime.ParseMediaType(mediaType)
+		if err != nil {
+			return err
+		}
+		encode := lookupEncoder(strings.ToLower(t))
+		if encode == nil {
+			return &MediaTypeError{MediaType: t}
+		}
+		if err := checkCycle(v); err != nil {
+			return err
+		}
+		var ct string
+		data, ct, err = encode(v, e)
+		if err != nil {
+			return err
+		}
+		if ct != "" {
+			contentType = ct
+		}
 	}
 
-	v := bytes.NewBuffer(jsonV)
-	req.Body = ioutil.NopCloser(v)
-	req.Header.Set("Content-Type", "application/json")
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 	if !chunked {
-		req.ContentLength = int64(v.Len())
+		req.ContentLength = int64(len(data))
 	}
 	return nil
 }
 
 // set option for http.Request
-// data, header, cookie, auth, file, json
+// data, header, cookie, file, json
 func (h H) setRequestOpt(req *http.Request) error {
 	if h.isConflict() {
 		return ErrParamConflict
 	}
 
+	e, err := newTextEncoder(h.Charset)
+	if err != nil {
+		return err
+	}
+
 	if h.Params != nil {
-		err := setQuery(req, h.Params)
+		err := setQuery(req, h.Params, e)
 		if err != nil {
 			return err
 		}
 	}
 
-	if h.Data != nil {
-		err := setData(req, h.Data, h.Chunked)
+	body, mediaType := h.body()
+	if body != nil {
+		err := setBody(req, body, mediaType, h.Chunked, h.BufferBody, e)
 		if err != nil {
 			return err
 		}
 	}
 
-	if h.Raw != "" {
-		v := strings.NewReader(h.Raw)
-		req.Body = ioutil.NopCloser(v)
-		if !h.Chunked {
-			req.ContentLength = int64(v.Len())
-		}
-	}
-
 	if h.Headers != nil {
 		for headerK, headerV := range h.Headers {
 			headerVS, ok := headerV.(string)
@@ -333,35 +505,24 @@ func (h H) setRequestOpt(req *http.Request) error {
 		}
 	}
 
-	if h.Auth != nil {
-		for k, v := range h.Auth {
-			vs, ok := v.(string)
-			if !ok {
-				return fmt.Errorf(
-					"nic: basic-auth %v[%T] must be string type",
-					v, v)
-			}
-			req.SetBasicAuth(k, vs)
-		}
-	}
-
-	if h.Files != nil {
-		err := setFiles(req, h.Files, h.Chunked)
-		if err != nil {
-			return err
-		}
-	}
-
-	if h.JSON != nil {
-		err := setJSON(req, h.JSON, h.Chunked)
-		if err != nil {
-			return err
+	// the charset of raw message is declared after headers are set
+	if _, raw := body.(string); raw && h.Charset != "" {
+		contentType := req.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "text/plain"
 		}
+		req.Header.Set("Content-Type", e.contentType(contentType))
 	}
 
 	return nil
 }
 
+// auth is applied by Session on every hop of the request,
+// so it won't be sent to third-party redirect targets
+func (h H) authenticator() Authenticator {
+	return h.Auth
+}
+
 // set option for http.Client
 // proxy, timeout, redirect
 func (h H) setClientOpt(client *http.Client) error {
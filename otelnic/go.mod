@@ -0,0 +1,28 @@
+module github.com/eddieivan01/nic/otelnic
+
+go 1.21
+
+require (
+	github.com/eddieivan01/nic v0.0.0-20261018225027-41c09ca27bc0
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/sdk v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+)
+
+require (
+	github.com/andybalholm/cascadia v1.1.0 // indirect
+	github.com/antchfx/xpath v1.1.6 // indirect
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/ugorji/go/codec v1.3.2 // indirect
+	go.opentelemetry.io/otel/metric v1.24.0 // indirect
+	golang.org/x/net v0.0.0-20200202094626-16171245cfb2 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	golang.org/x/text v0.3.2 // indirect
+	google.golang.org/protobuf v1.36.0 // indirect
+	gopkg.in/yaml.v2 v2.2.8 // indirect
+)
+
+// nic is developed in the parent directory, the replace is ignored
+// by downstream modules which get the version required above
+replace github.com/eddieivan01/nic => ../
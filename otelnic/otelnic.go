@@ -0,0 +1,85 @@
+// Package otelnic adapts OpenTelemetry to nic.Tracer,
+// it's a separate module so that nic itself doesn't depend on OpenTelemetry
+//
+//	session.SetTracer(otelnic.NewTracer(nil))
+//	resp, err := session.RequestContext(ctx, nic.GET, "https://example.com", nil)
+package otelnic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/eddieivan01/nic"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/eddieivan01/nic/otelnic"
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a nic.Tracer which creates client spans by tp,
+// the global TracerProvider is used if tp is nil
+func NewTracer(tp trace.TracerProvider) nic.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &tracer{tracer: tp.Tracer(instrumentationName)}
+}
+
+func (t *tracer) Start(ctx context.Context, req *http.Request) (context.Context, nic.Span) {
+	ctx, s := t.tracer.Start(ctx, "HTTP "+req.Method, trace.WithSpanKind(trace.SpanKindClient))
+	return ctx, &span{span: s}
+}
+
+type span struct {
+	span trace.Span
+}
+
+func (s *span) SpanContext() nic.SpanContext {
+	sc := s.span.SpanContext()
+	return nic.SpanContext{
+		TraceID:    sc.TraceID(),
+		SpanID:     sc.SpanID(),
+		Flags:      byte(sc.TraceFlags()),
+		TraceState: sc.TraceState().String(),
+	}
+}
+
+func (s *span) SetAttributes(attrs map[string]interface{}) {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		switch v := v.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(k, v))
+		case int:
+			kvs = append(kvs, attribute.Int(k, v))
+			if k == "http.response.status_code" && v >= 400 {
+				s.span.SetStatus(codes.Error, http.StatusText(v))
+			}
+		case int64:
+			kvs = append(kvs, attribute.Int64(k, v))
+		case float64:
+			kvs = append(kvs, attribute.Float64(k, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(k, v))
+		default:
+			kvs = append(kvs, attribute.String(k, fmt.Sprint(v)))
+		}
+	}
+	s.span.SetAttributes(kvs...)
+}
+
+func (s *span) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *span) End() {
+	s.span.End()
+}
@@ -0,0 +1,75 @@
+package otelnic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eddieivan01/nic"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func attrOf(s sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracer(t *testing.T) {
+	traceparent := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	session := nic.NewSession()
+	session.SetTracer(NewTracer(tp))
+
+	resp, err := session.Get(server.URL+"/otel", nil)
+	if err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Fatal("otel request error", err)
+	}
+	spans := exporter.GetSpans().Snapshots()
+	if len(spans) != 1 {
+		t.Fatal("otel spans error", len(spans))
+	}
+
+	s := spans[0]
+	if s.Name() != "HTTP GET" || s.SpanKind() != trace.SpanKindClient ||
+		s.Status().Code != codes.Error {
+		t.Fatal("otel span error", s.Name(), s.SpanKind(), s.Status())
+	}
+	if v, ok := attrOf(s, "http.response.status_code"); !ok || v.AsInt64() != 500 {
+		t.Fatal("otel status code attribute error", v)
+	}
+	if v, ok := attrOf(s, "url.full"); !ok || v.AsString() != server.URL+"/otel" {
+		t.Fatal("otel url attribute error", v)
+	}
+
+	sc := s.SpanContext()
+	if !strings.HasPrefix(traceparent, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-") {
+		t.Fatal("otel traceparent error", traceparent)
+	}
+
+	// transport errors are recorded too
+	exporter.Reset()
+	server.Close()
+	_, err = session.Get(server.URL, nil)
+	spans = exporter.GetSpans().Snapshots()
+	if err == nil || len(spans) != 1 || spans[0].Status().Code != codes.Error ||
+		len(spans[0].Events()) != 1 || spans[0].Events()[0].Name != "exception" {
+		t.Fatal("otel error span error")
+	}
+	t.Log("otel ok ✔")
+}
@@ -6,9 +6,6 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-	"strings"
-
-	"github.com/axgle/mahonia"
 )
 
 // Response is the wrapper for http.Response
@@ -17,6 +14,21 @@ type Response struct {
 	encoding string
 	Text     string
 	Bytes    []byte
+
+	// Cache tells whether the response is served from the session cache
+	Cache CacheStatus
+
+	// Timings tells where the time of the request goes
+	Timings Timings
+
+	// doc and xmlDoc are the parsed Text,
+	// see Response.HTML and Response.XMLDocument
+	doc    *Document
+	xmlDoc *XMLNode
+
+	// jsonTree is the decoded Bytes, see Response.JSONPath
+	jsonTree   interface{}
+	jsonParsed bool
 }
 
 func NewResponse(r *http.Response) (*Response, error) {
@@ -35,8 +47,16 @@ func NewResponse(r *http.Response) (*Response, error) {
 	return resp, nil
 }
 
+// text decodes Response.Bytes in the detected charset,
+// the body of a binary media type, e.g. `image/png`, is kept as is
 func (r *Response) text() {
-	r.Text = string(r.Bytes)
+	charset := detectCharset(r.Header.Get("Content-Type"), r.Bytes)
+	if charset == "" {
+		r.Text = string(r.Bytes)
+		return
+	}
+	r.encoding = charset
+	r.Text = decodeText(r.encoding, r.Bytes)
 }
 
 func (r *Response) bytes() error {
@@ -52,32 +72,38 @@ func (r *Response) bytes() error {
 	return nil
 }
 
-// JSON could parse http json response
-func (r Response) JSON(s interface{}) error {
-	// JSON response not must be `application/json` type
-	// maybe `text/plain`...etc.
-	// nic will parse it regardless of the content-type
-	/*
-		cType := r.Header.Get("Content-Type")
-		if !strings.Contains(cType, "json") {
-			return ErrNotJsonResponse
-		}
-	*/
+// JSON could parse http json response,
+// JSON response not must be `application/json` type
+// maybe `text/plain`...etc.
+// nic will parse it regardless of the content-type
+// unless mode is DecodeStrict, then ErrNotJsonResponse is returned
+func (r Response) JSON(s interface{}, mode ...DecodeMode) error {
+	if len(mode) > 0 && mode[0] == DecodeStrict && !isJSON(r.mediaType()) {
+		return ErrNotJsonResponse
+	}
 	err := json.Unmarshal(r.Bytes, s)
 	return err
 }
 
-// SetEncode changes Response.encoding
-// and it changes Response.Text every times be invoked
-func (r *Response) SetEncode(e string) error {
-	if r.encoding != e {
-		r.encoding = strings.ToLower(e)
-		decoder := mahonia.NewDecoder(e)
-		if decoder == nil {
-			return ErrUnrecognizedEncoding
-		}
-		r.Text = decoder.ConvertString(r.Text)
+// SetEncode changes Response.encoding and decodes Response.Bytes into Response.Text again,
+// e is a WHATWG encoding label, e.g. "gbk", "shift_jis", "latin1",
+// invalid bytes are replaced with U+FFFD unless mode is DecodeStrict
+func (r *Response) SetEncode(e string, mode ...DecodeMode) error {
+	charset := charsetName(e)
+	if charset == "" {
+		return ErrUnrecognizedEncoding
+	}
+
+	m := DecodeReplace
+	if len(mode) > 0 {
+		m = mode[0]
+	}
+	text, err := decode(charset, r.Bytes, m)
+	if err != nil {
+		return err
 	}
+	r.encoding, r.Text = charset, text
+	r.doc, r.xmlDoc = nil, nil
 	return nil
 }
 
@@ -1,12 +1,16 @@
 package nic
 
 import (
+	"context"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 )
 
 type (
@@ -16,6 +20,19 @@ type (
 		request                *http.Request
 		beforeRequestHookFuncs []BeforeRequestHookFunc
 		afterResponseHookFuncs []AfterResponseHookFunc
+		signer                 *Signer
+		auth                   *scopedAuth
+		netrc                  *scopedAuth
+		cookieFile             string
+		cache                  CacheStorage
+		offline                bool
+		cassette               *Cassette
+		har                    *HARRecorder
+		debug                  io.Writer
+		dumpOpt                DumpOptions
+		logger                 *sessionLogger
+		metrics                MetricsCollector
+		tracer                 Tracer
 		sync.Mutex
 	}
 )
@@ -39,8 +56,7 @@ var (
 // NewSession returns an empty Session
 func NewSession() *Session {
 	client := &http.Client{}
-	jar, _ := cookiejar.New(nil)
-	client.Jar = jar
+	client.Jar = NewJar()
 	client.Transport = &http.Transport{}
 
 	return &Session{
@@ -50,6 +66,12 @@ func NewSession() *Session {
 
 // Request is the base method
 func (s *Session) Request(method string, urlStr string, option Option) (*Response, error) {
+	return s.RequestContext(context.Background(), method, urlStr, option)
+}
+
+// RequestContext is Request with a context, which could cancel the request
+// or carry the span to propagate
+func (s *Session) RequestContext(ctx context.Context, method string, urlStr string, option Option) (*Response, error) {
 	s.Lock()
 	defer s.Unlock()
 
@@ -63,7 +85,7 @@ func (s *Session) Request(method string, urlStr string, option Option) (*Respons
 		}
 		urlStrParsed.RawQuery = urlStrParsed.Query().Encode()
 
-		s.request, err = http.NewRequest(method, urlStrParsed.String(), nil)
+		s.request, err = http.NewRequestWithContext(ctx, method, urlStrParsed.String(), nil)
 		if err != nil {
 			return nil, err
 		}
@@ -72,8 +94,7 @@ func (s *Session) Request(method string, urlStr string, option Option) (*Respons
 
 		if s.Client == nil {
 			s.Client = &http.Client{}
-			jar, _ := cookiejar.New(nil)
-			s.Client.Jar = jar
+			s.Client.Jar = NewJar()
 			s.Client.Transport = &http.Transport{}
 		}
 
@@ -105,14 +126,69 @@ func (s *Session) Request(method string, urlStr string, option Option) (*Respons
 			}
 		}
 
+		if s.signer != nil {
+			err = s.signer.Sign(s.request)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 	default:
 		return nil, ErrInvalidMethod
 	}
 
+	// H.Auth is scoped to the request's host,
+	// otherwise the session's default authenticator or netrc is used
+	var scope *scopedAuth
+	if option != nil && option.authenticator() != nil {
+		scope = &scopedAuth{
+			auth:  option.authenticator(),
+			hosts: []string{s.request.URL.Host},
+		}
+	} else if s.auth != nil && s.auth.match(s.request.URL.Host) {
+		scope = s.auth
+	} else if s.netrc != nil {
+		scope = s.netrc
+	}
+
+	// the body may be sent twice, e.g. digest auth,
+	// or exported, e.g. Session.Curl, bodies set by options are replayable already,
+	// the ones set by hooks are buffered, H.BodyReader is streamed
+	if _, stream := s.request.Body.(*streamBody); s.request.GetBody == nil && !stream {
+		_, err := peekRequestBody(s.request)
+		if err != nil {
+			return nil, err
+		}
+	}
+	bytesOut := bodySize(s.request)
+
+	var span Span
+	s.request, span = s.startSpan(s.request)
+
+	ex := &exchange{secrets: scope.secrets()}
+	timer := newTimer()
+	ctx = context.WithValue(s.request.Context(), exchangeKey{}, ex)
+	s.request = s.request.WithContext(httptrace.WithClientTrace(ctx, timer.trace()))
+
+	// the client is copied so that the transport could be wrapped
+	// without breaking the *http.Transport options
+	client := *s.Client
+	client.Transport = s.wrapTransport(transportOf(&client), scope)
+
 	// do request then parse response
-	r, err := s.Client.Do(s.request)
+	start := time.Now()
+	s.logRequest(s.request)
+	if s.metrics != nil {
+		s.metrics.RequestStarted(method, s.request.URL.Host)
+	}
+	r, err := client.Do(s.request)
 	if err != nil {
-		return nil, err
+		endSpan(span, ex, nil, err)
+		if s.metrics != nil {
+			s.metrics.RequestFinished(method, s.request.URL.Host, 0,
+				time.Since(start), bytesOut, 0)
+		}
+		return nil, s.logError(s.request, err, start)
 	}
 
 	for _, fn := range s.afterResponseHookFuncs {
@@ -123,18 +199,209 @@ func (s *Session) Request(method string, urlStr string, option Option) (*Respons
 	}
 
 	resp, err := NewResponse(r)
+	if s.metrics != nil {
+		status, n := 0, int64(0)
+		if err == nil {
+			status, n = resp.StatusCode, int64(len(resp.Bytes))
+		}
+		s.metrics.RequestFinished(method, s.request.URL.Host, status,
+			time.Since(start), bytesOut, n)
+	}
+	endSpan(span, ex, resp, err)
 	if err != nil {
-		return nil, err
+		return nil, s.logError(s.request, err, start)
+	}
+	resp.Cache = ex.cache
+	resp.Timings = timer.timings()
+	if s.logger != nil {
+		s.logger.response(s.request, resp, time.Since(start))
+	}
+
+	if s.cookieFile != "" {
+		// the response is still good when the cookies can't be saved
+		jar, err := s.jar()
+		if err == nil {
+			err = saveJar(jar, s.cookieFile)
+		}
+		if err != nil {
+			return resp, err
+		}
 	}
 
 	return resp, nil
 }
 
+// bodySize returns the size of the request body without reading a stream
+func bodySize(req *http.Request) int64 {
+	if req.ContentLength > 0 {
+		return req.ContentLength
+	}
+	if req.GetBody == nil {
+		return 0
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return 0
+	}
+	defer rc.Close()
+	n, _ := io.Copy(ioutil.Discard, rc)
+	return n
+}
+
+// exchange collects what happens to a request inside the transports
+type exchange struct {
+	cache CacheStatus
+
+	// names of the API keys to redact in logs
+	secrets []string
+
+	// the previous hop, for redirects and retries
+	last       *url.URL
+	lastStatus int
+	retries    int
+	redirects  int
+
+	// the next hop resends the previous one, e.g. answering a digest challenge
+	resend bool
+}
+
+// hopTransport reports redirects and retries of every hop
+// to the logger, metrics and tracer
+type hopTransport struct {
+	base    http.RoundTripper
+	log     *sessionLogger
+	metrics MetricsCollector
+}
+
+func (t *hopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ex := exchangeOf(req)
+	if ex != nil && ex.last != nil {
+		// a hop is a redirect unless it's marked as a resend,
+		// even if it goes back to the same URL
+		retry := ex.resend
+		ex.resend = false
+		if retry {
+			ex.retries++
+		} else {
+			ex.redirects++
+		}
+		if t.log != nil {
+			t.log.hop(req, ex.last, ex.lastStatus, retry)
+		}
+		if t.metrics != nil && retry {
+			t.metrics.Retried(req.Method, req.URL.Host)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if ex != nil {
+		ex.last, ex.lastStatus = req.URL, 0
+		if resp != nil {
+			ex.lastStatus = resp.StatusCode
+		}
+	}
+	return resp, err
+}
+
+type exchangeKey struct{}
+
+func exchangeOf(req *http.Request) *exchange {
+	ex, _ := req.Context().Value(exchangeKey{}).(*exchange)
+	return ex
+}
+
+// wrapTransport stacks the session's features on the base transport,
+// the outer one sees a request first
+func (s *Session) wrapTransport(base http.RoundTripper, scope *scopedAuth) http.RoundTripper {
+	rt := base
+	if s.debug != nil {
+		rt = &dumpTransport{
+			base: rt,
+			w:    s.debug,
+			opt:  s.dumpOpt,
+		}
+	}
+	if s.offline {
+		rt = offlineTransport{}
+	}
+	if s.cassette != nil {
+		rt = &cassetteTransport{
+			base:     rt,
+			cassette: s.cassette,
+			secrets:  scope.secrets(),
+		}
+	}
+	if s.har != nil {
+		rt = &harTransport{
+			base:     rt,
+			recorder: s.har,
+			secrets:  scope.secrets(),
+		}
+	}
+	if s.logger != nil || s.metrics != nil || s.tracer != nil {
+		rt = &hopTransport{
+			base:    rt,
+			log:     s.logger,
+			metrics: s.metrics,
+		}
+	}
+	if scope != nil {
+		rt = &authTransport{
+			base:  rt,
+			scope: scope,
+		}
+	}
+	if s.cache != nil {
+		rt = &cacheTransport{
+			base:    rt,
+			storage: s.cache,
+			auth:    scope,
+			offline: s.offline,
+		}
+	}
+	return rt
+}
+
+func transportOf(client *http.Client) http.RoundTripper {
+	if client.Transport == nil {
+		return http.DefaultTransport
+	}
+	return client.Transport
+}
+
 // GetRequest returns nic.Session.request
 func (s *Session) GetRequest() *http.Request {
 	return s.request
 }
 
+// SetSigner signs every request with signer after hook functions,
+// pass nil to disable it
+func (s *Session) SetSigner(signer *Signer) {
+	s.Lock()
+	defer s.Unlock()
+	s.signer = signer
+}
+
+// SetAuth sets the default authenticator used when H.Auth is not given,
+// it's only applied to the hosts, e.g. "api.example.com", "*.example.com",
+// pass nil to disable it
+func (s *Session) SetAuth(auth Authenticator, hosts ...string) error {
+	s.Lock()
+	defer s.Unlock()
+	if auth == nil {
+		s.auth = nil
+		return nil
+	}
+	if len(hosts) == 0 {
+		return ErrAuthNoHost
+	}
+	s.auth = &scopedAuth{
+		auth:  auth,
+		hosts: hosts,
+	}
+	return nil
+}
+
 type (
 	BeforeRequestHookFunc func(*http.Request) error
 	AfterResponseHookFunc func(*http.Response) error
@@ -0,0 +1,496 @@
+package nic
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// AlgHMACSHA256 is the `hmac-sha256` algorithm of RFC 9421
+	AlgHMACSHA256 = "hmac-sha256"
+	// AlgEd25519 is the `ed25519` algorithm of RFC 9421
+	AlgEd25519 = "ed25519"
+)
+
+// DefaultSignComponents are covered when Signer.Components is empty
+var DefaultSignComponents = []string{"@method", "@path", "content-digest", "date"}
+
+// Signer adds `Content-Digest`, `Signature-Input` and `Signature` headers
+// to a message, as described in RFC 9421 (HTTP Message Signatures)
+//
+//	session.SetSigner(nic.HMACSigner("key-1", secret).
+//		Cover("@method", "@path", "content-digest", "date"))
+type Signer struct {
+	KeyID      string
+	Label      string
+	Alg        string
+	Components []string
+
+	// []byte for hmac-sha256, ed25519.PrivateKey for ed25519
+	Key interface{}
+
+	// Expires is the lifetime of signature, zero means no `expires` param
+	Expires time.Duration
+}
+
+// HMACSigner returns a hmac-sha256 signer
+func HMACSigner(keyID string, secret []byte) *Signer {
+	return &Signer{
+		KeyID: keyID,
+		Alg:   AlgHMACSHA256,
+		Key:   secret,
+	}
+}
+
+// Ed25519Signer returns a ed25519 signer
+func Ed25519Signer(keyID string, key ed25519.PrivateKey) *Signer {
+	return &Signer{
+		KeyID: keyID,
+		Alg:   AlgEd25519,
+		Key:   key,
+	}
+}
+
+// Cover changes the covered components
+// invoke it in a chain
+func (s *Signer) Cover(components ...string) *Signer {
+	s.Components = components
+	return s
+}
+
+// Tag changes the signature label, default is `sig1`
+// invoke it in a chain
+func (s *Signer) Tag(label string) *Signer {
+	s.Label = label
+	return s
+}
+
+// sigMessage is the common view of http.Request and http.Response
+type sigMessage struct {
+	method string
+	url    *url.URL
+	host   string
+	status int
+	header http.Header
+	body   []byte
+}
+
+func requestMessage(req *http.Request) (*sigMessage, error) {
+	body, err := peekRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	// a server side request only carries the request target,
+	// the absolute URI is rebuilt as RFC 9421 section 2.2.2 says
+	u := *req.URL
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	u.Host = host
+	return &sigMessage{
+		method: req.Method,
+		url:    &u,
+		host:   host,
+		header: req.Header,
+		body:   body,
+	}, nil
+}
+
+func responseMessage(resp *http.Response) (*sigMessage, error) {
+	var body []byte
+	if resp.Body != nil {
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+		body = data
+	}
+	return &sigMessage{
+		status: resp.StatusCode,
+		header: resp.Header,
+		body:   body,
+	}, nil
+}
+
+// peekRequestBody reads the body then puts it back
+func peekRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return data, nil
+}
+
+func contentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func (m *sigMessage) component(name string) (string, error) {
+	switch name {
+	case "@method":
+		return strings.ToUpper(m.method), nil
+	case "@target-uri":
+		return m.url.String(), nil
+	case "@authority":
+		return strings.ToLower(m.host), nil
+	case "@scheme":
+		return strings.ToLower(m.url.Scheme), nil
+	case "@request-target":
+		return m.url.RequestURI(), nil
+	case "@path":
+		p := m.url.EscapedPath()
+		if p == "" {
+			p = "/"
+		}
+		return p, nil
+	case "@query":
+		return "?" + m.url.RawQuery, nil
+	case "@status":
+		return strconv.Itoa(m.status), nil
+	}
+
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("nic: unknown signature component %s", name)
+	}
+
+	values := m.header.Values(name)
+	if len(values) == 0 {
+		return "", fmt.Errorf("nic: signature component %s is missing", name)
+	}
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.TrimSpace(v)
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+// signatureBase builds the signature base of RFC 9421 section 2.5
+func (m *sigMessage) signatureBase(components []string, params string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for _, c := range components {
+		if m.url == nil && strings.HasPrefix(c, "@") && c != "@status" {
+			return nil, fmt.Errorf("nic: signature component %s is not available in response", c)
+		}
+		v, err := m.component(c)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(buf, "%q: %s\n", c, v)
+	}
+	fmt.Fprintf(buf, "%q: %s", "@signature-params", params)
+	return buf.Bytes(), nil
+}
+
+// components returns the covered components,
+// identifiers are lowercase as RFC 9421 section 2.1 says
+func (s *Signer) components() []string {
+	if len(s.Components) == 0 {
+		return DefaultSignComponents
+	}
+	components := make([]string, len(s.Components))
+	for i, c := range s.Components {
+		components[i] = strings.ToLower(c)
+	}
+	return components
+}
+
+func (s *Signer) label() string {
+	if s.Label == "" {
+		return "sig1"
+	}
+	return s.Label
+}
+
+func (s *Signer) sign(m *sigMessage) error {
+	components := s.components()
+	for _, c := range components {
+		switch c {
+		case "content-digest":
+			m.header.Set("Content-Digest", contentDigest(m.body))
+		case "date":
+			if m.header.Get("Date") == "" {
+				m.header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+			}
+		}
+	}
+
+	quoted := make([]string, len(components))
+	for i, c := range components {
+		quoted[i] = strconv.Quote(c)
+	}
+	now := time.Now()
+	params := fmt.Sprintf("(%s);created=%d", strings.Join(quoted, " "), now.Unix())
+	if s.Expires > 0 {
+		params += fmt.Sprintf(";expires=%d", now.Add(s.Expires).Unix())
+	}
+	params += fmt.Sprintf(";keyid=%q;alg=%q", s.KeyID, s.Alg)
+
+	base, err := m.signatureBase(components, params)
+	if err != nil {
+		return err
+	}
+
+	var sig []byte
+	switch s.Alg {
+	case AlgHMACSHA256:
+		key, ok := s.Key.([]byte)
+		if !ok {
+			return fmt.Errorf("nic: hmac-sha256 key %T must be []byte type", s.Key)
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(base)
+		sig = mac.Sum(nil)
+	case AlgEd25519:
+		key, ok := s.Key.(ed25519.PrivateKey)
+		if !ok {
+			return fmt.Errorf("nic: ed25519 key %T must be ed25519.PrivateKey type", s.Key)
+		}
+		sig = ed25519.Sign(key, base)
+	default:
+		return fmt.Errorf("nic: unsupported signature algorithm %s", s.Alg)
+	}
+
+	label := s.label()
+	m.header.Set("Signature-Input", label+"="+params)
+	m.header.Set("Signature", label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+}
+
+// Sign signs the request
+func (s *Signer) Sign(req *http.Request) error {
+	m, err := requestMessage(req)
+	if err != nil {
+		return err
+	}
+	return s.sign(m)
+}
+
+// SignResponse signs the response, e.g. a recorded webhook reply
+func (s *Signer) SignResponse(resp *http.Response) error {
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	m, err := responseMessage(resp)
+	if err != nil {
+		return err
+	}
+	return s.sign(m)
+}
+
+// Verifier checks the message signatures made by Signer
+type Verifier struct {
+	keys map[string]verifyKey
+}
+
+type verifyKey struct {
+	alg string
+	key interface{}
+}
+
+// NewVerifier returns a Verifier without any key
+func NewVerifier() *Verifier {
+	return &Verifier{
+		keys: make(map[string]verifyKey),
+	}
+}
+
+// HMACKey adds a hmac-sha256 key
+// invoke it in a chain
+func (v *Verifier) HMACKey(keyID string, secret []byte) *Verifier {
+	v.keys[keyID] = verifyKey{AlgHMACSHA256, secret}
+	return v
+}
+
+// Ed25519Key adds a ed25519 public key
+// invoke it in a chain
+func (v *Verifier) Ed25519Key(keyID string, key ed25519.PublicKey) *Verifier {
+	v.keys[keyID] = verifyKey{AlgEd25519, key}
+	return v
+}
+
+// VerifyRequest checks all signatures of the request
+func (v *Verifier) VerifyRequest(req *http.Request) error {
+	m, err := requestMessage(req)
+	if err != nil {
+		return err
+	}
+	return v.verify(m)
+}
+
+// VerifyResponse checks all signatures of the response
+func (v *Verifier) VerifyResponse(resp *http.Response) error {
+	m, err := responseMessage(resp)
+	if err != nil {
+		return err
+	}
+	return v.verify(m)
+}
+
+func (v *Verifier) verify(m *sigMessage) error {
+	inputs := splitDictionary(strings.Join(m.header.Values("Signature-Input"), ", "))
+	sigs := splitDictionary(strings.Join(m.header.Values("Signature"), ", "))
+	if len(inputs) == 0 {
+		return ErrInvalidSignature
+	}
+
+	for label, params := range inputs {
+		raw, ok := sigs[label]
+		if !ok || len(raw) < 2 || raw[0] != ':' || raw[len(raw)-1] != ':' {
+			return ErrInvalidSignature
+		}
+		sig, err := base64.StdEncoding.DecodeString(raw[1 : len(raw)-1])
+		if err != nil {
+			return ErrInvalidSignature
+		}
+
+		components, p, err := parseSignatureParams(params)
+		if err != nil {
+			return err
+		}
+
+		if exp, ok := p["expires"]; ok {
+			t, err := strconv.ParseInt(exp, 10, 64)
+			if err != nil || time.Now().Unix() > t {
+				return ErrInvalidSignature
+			}
+		}
+
+		key, ok := v.keys[p["keyid"]]
+		if !ok {
+			return fmt.Errorf("nic: unknown signature keyid %q", p["keyid"])
+		}
+		if alg, ok := p["alg"]; ok && alg != key.alg {
+			return ErrInvalidSignature
+		}
+
+		for _, c := range components {
+			if c == "content-digest" && m.header.Get("Content-Digest") != contentDigest(m.body) {
+				return ErrDigestMismatch
+			}
+		}
+
+		base, err := m.signatureBase(components, params)
+		if err != nil {
+			return err
+		}
+
+		switch key.alg {
+		case AlgHMACSHA256:
+			mac := hmac.New(sha256.New, key.key.([]byte))
+			mac.Write(base)
+			if subtle.ConstantTimeCompare(mac.Sum(nil), sig) != 1 {
+				return ErrInvalidSignature
+			}
+		case AlgEd25519:
+			if !ed25519.Verify(key.key.(ed25519.PublicKey), base, sig) {
+				return ErrInvalidSignature
+			}
+		}
+	}
+	return nil
+}
+
+// splitDictionary splits a structured field dictionary into raw members
+// e.g. `sig1=("@method");keyid="a", sig2=:YWJj:`
+func splitDictionary(s string) map[string]string {
+	members := make(map[string]string)
+	depth, quoted, start := 0, false, 0
+
+	add := func(member string) {
+		member = strings.TrimSpace(member)
+		i := strings.IndexByte(member, '=')
+		if i > 0 {
+			members[member[:i]] = member[i+1:]
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted:
+			depth--
+		case c == ',' && !quoted && depth == 0:
+			add(s[start:i])
+			start = i + 1
+		}
+	}
+	add(s[start:])
+	return members
+}
+
+// parseSignatureParams parses `("@method" "@path");created=1;keyid="a"`
+func parseSignatureParams(s string) ([]string, map[string]string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, nil, ErrInvalidSignature
+	}
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, nil, ErrInvalidSignature
+	}
+
+	components := make([]string, 0)
+	for _, item := range strings.Fields(s[1:end]) {
+		c, err := strconv.Unquote(item)
+		if err != nil || c != strings.ToLower(c) {
+			// component identifiers must be lowercase
+			return nil, nil, ErrInvalidSignature
+		}
+		components = append(components, c)
+	}
+
+	params := make(map[string]string)
+	for _, p := range strings.Split(s[end+1:], ";") {
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, nil, ErrInvalidSignature
+		}
+		v := kv[1]
+		if strings.HasPrefix(v, `"`) {
+			uv, err := strconv.Unquote(v)
+			if err != nil {
+				return nil, nil, ErrInvalidSignature
+			}
+			v = uv
+		}
+		params[strings.TrimSpace(kv[0])] = v
+	}
+	return components, params, nil
+}
@@ -0,0 +1,119 @@
+package nic
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings tells where the time of a request goes, phases which
+// didn't happen, e.g. DNS of a reused connection, are zero,
+// phases are of the last hop if redirected
+type Timings struct {
+	// DNSLookup is the time of resolving the host
+	DNSLookup time.Duration
+	// Connect is the time of the TCP connection
+	Connect time.Duration
+	// TLSHandshake is the time of the TLS handshake
+	TLSHandshake time.Duration
+	// FirstByte is the time from asking for a connection
+	// to the first byte of the response, a.k.a. TTFB
+	FirstByte time.Duration
+	// Transfer is the time of reading the response body
+	Transfer time.Duration
+	// Total is the time of the whole call, including redirects and retries
+	Total time.Duration
+
+	// ConnReused tells whether the connection was reused
+	ConnReused bool
+	// ConnIdle is how long the reused connection was idle
+	ConnIdle time.Duration
+	// RemoteAddr is the address of the server, e.g. "1.2.3.4:443"
+	RemoteAddr string
+}
+
+// timer collects timings by httptrace
+type timer struct {
+	start, getConn, gotConn       time.Time
+	dnsStart, dnsDone             time.Time
+	connectStart, connectDone     time.Time
+	tlsStart, tlsDone             time.Time
+	wroteRequest, firstByte, done time.Time
+	reused                        bool
+	idle                          time.Duration
+	remoteAddr                    net.Addr
+	sync.Mutex
+}
+
+func newTimer() *timer {
+	return &timer{start: time.Now()}
+}
+
+func (t *timer) trace() *httptrace.ClientTrace {
+	now := func(p *time.Time) {
+		t.Lock()
+		*p = time.Now()
+		t.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			// a new hop, e.g. a redirect, starts over
+			t.Lock()
+			t.getConn, t.gotConn = time.Now(), time.Time{}
+			t.dnsStart, t.dnsDone = time.Time{}, time.Time{}
+			t.connectStart, t.connectDone = time.Time{}, time.Time{}
+			t.tlsStart, t.tlsDone = time.Time{}, time.Time{}
+			t.wroteRequest, t.firstByte = time.Time{}, time.Time{}
+			t.reused, t.idle, t.remoteAddr = false, 0, nil
+			t.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			now(&t.gotConn)
+			t.Lock()
+			t.reused, t.idle = info.Reused, info.IdleTime
+			t.remoteAddr = info.Conn.RemoteAddr()
+			t.Unlock()
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { now(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { now(&t.dnsDone) },
+		ConnectStart:         func(string, string) { now(&t.connectStart) },
+		ConnectDone:          func(string, string, error) { now(&t.connectDone) },
+		TLSHandshakeStart:    func() { now(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { now(&t.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { now(&t.wroteRequest) },
+		GotFirstResponseByte: func() { now(&t.firstByte) },
+	}
+}
+
+func since(from, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+// timings stops the timer and returns the result
+func (t *timer) timings() Timings {
+	t.Lock()
+	defer t.Unlock()
+	if t.done.IsZero() {
+		t.done = time.Now()
+	}
+
+	timings := Timings{
+		DNSLookup:    since(t.dnsStart, t.dnsDone),
+		Connect:      since(t.connectStart, t.connectDone),
+		TLSHandshake: since(t.tlsStart, t.tlsDone),
+		FirstByte:    since(t.getConn, t.firstByte),
+		Transfer:     since(t.firstByte, t.done),
+		Total:        since(t.start, t.done),
+		ConnReused:   t.reused,
+		ConnIdle:     t.idle,
+	}
+	if t.remoteAddr != nil {
+		timings.RemoteAddr = t.remoteAddr.String()
+	}
+	return timings
+}
@@ -0,0 +1,189 @@
+package nic
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SpanContext is the identity of a span propagated by W3C Trace Context
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Flags      byte
+	TraceState string
+}
+
+// IsValid reports whether both the trace and span IDs are non-zero
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Sampled reports whether the sampled flag is set
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&1 == 1
+}
+
+// Traceparent returns the `traceparent` header value
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x",
+		hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), sc.Flags)
+}
+
+// Child returns a span context of the same trace with a random span ID
+func (sc SpanContext) Child() SpanContext {
+	if sc.TraceID == [16]byte{} {
+		rand.Read(sc.TraceID[:])
+		sc.Flags = 1
+	}
+	rand.Read(sc.SpanID[:])
+	return sc
+}
+
+// ParseTraceparent parses the `traceparent` and `tracestate` header values
+func ParseTraceparent(traceparent, tracestate string) (SpanContext, error) {
+	sc := SpanContext{TraceState: tracestate}
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("nic: invalid traceparent %q", traceparent)
+	}
+
+	fields := []struct {
+		dst []byte
+		src string
+	}{
+		{sc.TraceID[:], parts[1]},
+		{sc.SpanID[:], parts[2]},
+		{[]byte{0}, parts[3]},
+	}
+	for _, f := range fields {
+		if len(f.src) != hex.EncodedLen(len(f.dst)) || strings.ToLower(f.src) != f.src {
+			return sc, fmt.Errorf("nic: invalid traceparent %q", traceparent)
+		}
+		if _, err := hex.Decode(f.dst, []byte(f.src)); err != nil {
+			return sc, fmt.Errorf("nic: invalid traceparent %q", traceparent)
+		}
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	sc.Flags = flags[0]
+
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("nic: invalid traceparent %q", traceparent)
+	}
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a context carrying sc,
+// requests made by Session.RequestContext with it propagate sc
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context carried by ctx
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Span is a client span created by a Tracer
+type Span interface {
+	// SpanContext returns the identity injected as `traceparent` and `tracestate`
+	SpanContext() SpanContext
+
+	// SetAttributes annotates the span,
+	// keys follow OpenTelemetry semantic conventions
+	SetAttributes(attrs map[string]interface{})
+
+	// RecordError marks the span failed
+	RecordError(err error)
+
+	// End finishes the span
+	End()
+}
+
+// Tracer creates a client span for every Session.Request,
+// implement it to adapt a tracing library, e.g. OpenTelemetry
+type Tracer interface {
+	// Start starts a span as the child of the one in ctx if any,
+	// the returned context carries the new span
+	Start(ctx context.Context, req *http.Request) (context.Context, Span)
+}
+
+// inject sets `traceparent` and `tracestate` of a valid span context
+func inject(req *http.Request, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	req.Header.Set("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		req.Header.Set("tracestate", sc.TraceState)
+	} else {
+		req.Header.Del("tracestate")
+	}
+}
+
+// startSpan starts the client span of the request and propagates it,
+// without a tracer the span context carried by the request's context
+// is propagated as is
+func (s *Session) startSpan(req *http.Request) (*http.Request, Span) {
+	ctx := req.Context()
+	if s.tracer == nil {
+		if sc, ok := SpanContextFromContext(ctx); ok {
+			inject(req, sc)
+		}
+		return req, nil
+	}
+
+	ctx, span := s.tracer.Start(ctx, req)
+	if span == nil {
+		return req, nil
+	}
+	req = req.WithContext(ctx)
+	inject(req, span.SpanContext())
+	span.SetAttributes(map[string]interface{}{
+		"http.request.method": req.Method,
+		"url.full":            req.URL.String(),
+		"server.address":      req.URL.Hostname(),
+	})
+	return req, span
+}
+
+// endSpan annotates the span with the status, retries and error then ends it
+func endSpan(span Span, ex *exchange, resp *Response, err error) {
+	if span == nil {
+		return
+	}
+	attrs := map[string]interface{}{}
+	if ex.retries > 0 {
+		attrs["http.request.resend_count"] = ex.retries
+	}
+	if ex.redirects > 0 {
+		attrs["nic.redirect_count"] = ex.redirects
+	}
+	if resp != nil {
+		attrs["http.response.status_code"] = resp.StatusCode
+		attrs["http.response.body.size"] = len(resp.Bytes)
+		if resp.StatusCode >= 400 {
+			attrs["error.type"] = fmt.Sprint(resp.StatusCode)
+		}
+	}
+	span.SetAttributes(attrs)
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// SetTracer creates a client span for every request by tracer,
+// pass nil to disable it
+func (s *Session) SetTracer(tracer Tracer) {
+	s.Lock()
+	defer s.Unlock()
+	s.tracer = tracer
+}
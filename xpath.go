@@ -0,0 +1,511 @@
+package nic
+
+import (
+	"encoding/xml"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// XMLNode is a node of a parsed XML document
+type XMLNode struct {
+	// Type is one of xml.StartElement, xml.CharData, xml.Comment,
+	// xml.ProcInst and xml.Directive, nil for the root
+	Type interface{}
+
+	// Name is the element's name, Space is the namespace URI
+	Name   xml.Name
+	Prefix string
+	Attr   []XMLAttr
+
+	// Data is the content of text, comment, etc.
+	Data string
+
+	Parent, FirstChild, LastChild, PrevSibling, NextSibling *XMLNode
+}
+
+// XMLAttr is an attribute of XMLNode
+type XMLAttr struct {
+	Name   xml.Name
+	Prefix string
+	Value  string
+}
+
+func (n *XMLNode) appendChild(c *XMLNode) {
+	c.Parent = n
+	if n.LastChild == nil {
+		n.FirstChild = c
+	} else {
+		n.LastChild.NextSibling = c
+		c.PrevSibling = n.LastChild
+	}
+	n.LastChild = c
+}
+
+// Text returns the combined text of the node and its descendants
+func (n *XMLNode) Text() string {
+	if _, ok := n.Type.(xml.CharData); ok {
+		return n.Data
+	}
+	b := &strings.Builder{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == nil {
+			continue
+		}
+		switch c.Type.(type) {
+		case xml.StartElement, xml.CharData:
+			b.WriteString(c.Text())
+		}
+	}
+	return b.String()
+}
+
+// ParseXML parses an utf-8 XML document, the encoding
+// in the XML declaration is ignored
+func ParseXML(s string) (*XMLNode, error) {
+	decoder := xml.NewDecoder(strings.NewReader(s))
+	decoder.Strict = false
+	decoder.CharsetReader = func(label string, r io.Reader) (io.Reader, error) {
+		return r, nil
+	}
+
+	root := &XMLNode{}
+	cur := root
+	// namespace bindings of the open elements
+	scopes := []map[string]string{{"xml": "http://www.w3.org/XML/1998/namespace"}}
+	resolve := func(prefix string) string {
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if uri, ok := scopes[i][prefix]; ok {
+				return uri
+			}
+		}
+		return ""
+	}
+
+	for {
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			scope := make(map[string]string)
+			for _, a := range tok.Attr {
+				switch {
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					scope[""] = a.Value
+				case a.Name.Space == "xmlns":
+					scope[a.Name.Local] = a.Value
+				}
+			}
+			scopes = append(scopes, scope)
+
+			n := &XMLNode{
+				Type:   tok.Copy(),
+				Name:   xml.Name{Space: resolve(tok.Name.Space), Local: tok.Name.Local},
+				Prefix: tok.Name.Space,
+			}
+			for _, a := range tok.Attr {
+				if (a.Name.Space == "" && a.Name.Local == "xmlns") || a.Name.Space == "xmlns" {
+					continue
+				}
+				attr := XMLAttr{Name: xml.Name{Local: a.Name.Local}, Prefix: a.Name.Space, Value: a.Value}
+				// unprefixed attributes have no namespace
+				if a.Name.Space != "" {
+					attr.Name.Space = resolve(a.Name.Space)
+				}
+				n.Attr = append(n.Attr, attr)
+			}
+			cur.appendChild(n)
+			cur = n
+
+		case xml.EndElement:
+			if cur.Parent == nil {
+				return nil, &xml.SyntaxError{Msg: "unexpected end element </" + tok.Name.Local + ">"}
+			}
+			scopes = scopes[:len(scopes)-1]
+			cur = cur.Parent
+
+		case xml.CharData:
+			cur.appendChild(&XMLNode{Type: tok.Copy(), Data: string(tok)})
+		case xml.Comment:
+			cur.appendChild(&XMLNode{Type: tok.Copy(), Data: string(tok)})
+		case xml.ProcInst:
+			cur.appendChild(&XMLNode{Type: tok.Copy(), Name: xml.Name{Local: tok.Target}, Data: string(tok.Inst)})
+		case xml.Directive:
+			cur.appendChild(&XMLNode{Type: tok.Copy(), Data: string(tok)})
+		}
+	}
+	return root, nil
+}
+
+// XPathNode is a node selected by Response.XPath
+type XPathNode struct {
+	// Name is the local name of an element or attribute
+	Name string
+	// Value is the string value, e.g. text of an element
+	Value string
+
+	// HTML is the node, or the owner element of the attribute,
+	// if the document is HTML
+	HTML *html.Node
+	// XML is the node, or the owner element of the attribute,
+	// if the document is XML
+	XML *XMLNode
+}
+
+// htmlNavigator implements xpath.NodeNavigator on *html.Node
+type htmlNavigator struct {
+	root, cur *html.Node
+	attr      int
+}
+
+func (h *htmlNavigator) NodeType() xpath.NodeType {
+	switch h.cur.Type {
+	case html.CommentNode:
+		return xpath.CommentNode
+	case html.TextNode:
+		return xpath.TextNode
+	case html.ElementNode:
+		if h.attr != -1 {
+			return xpath.AttributeNode
+		}
+		return xpath.ElementNode
+	}
+	// the document and doctype
+	return xpath.RootNode
+}
+
+func (h *htmlNavigator) LocalName() string {
+	if h.attr != -1 {
+		return h.cur.Attr[h.attr].Key
+	}
+	return h.cur.Data
+}
+
+func (h *htmlNavigator) Prefix() string {
+	return ""
+}
+
+func (h *htmlNavigator) Value() string {
+	switch h.cur.Type {
+	case html.CommentNode, html.TextNode:
+		return h.cur.Data
+	case html.ElementNode:
+		if h.attr != -1 {
+			return h.cur.Attr[h.attr].Val
+		}
+	}
+	return (&Selection{Nodes: []*html.Node{h.cur}}).Text()
+}
+
+func (h *htmlNavigator) Copy() xpath.NodeNavigator {
+	c := *h
+	return &c
+}
+
+func (h *htmlNavigator) MoveToRoot() {
+	h.cur, h.attr = h.root, -1
+}
+
+func (h *htmlNavigator) MoveToParent() bool {
+	if h.attr != -1 {
+		h.attr = -1
+		return true
+	}
+	if h.cur.Parent == nil {
+		return false
+	}
+	h.cur = h.cur.Parent
+	return true
+}
+
+func (h *htmlNavigator) MoveToNextAttribute() bool {
+	if h.attr >= len(h.cur.Attr)-1 {
+		return false
+	}
+	h.attr++
+	return true
+}
+
+func (h *htmlNavigator) MoveToChild() bool {
+	if h.attr != -1 || h.cur.FirstChild == nil {
+		return false
+	}
+	h.cur = h.cur.FirstChild
+	return true
+}
+
+func (h *htmlNavigator) MoveToFirst() bool {
+	if h.attr != -1 || h.cur.PrevSibling == nil {
+		return false
+	}
+	for h.cur.PrevSibling != nil {
+		h.cur = h.cur.PrevSibling
+	}
+	return true
+}
+
+func (h *htmlNavigator) MoveToNext() bool {
+	if h.attr != -1 || h.cur.NextSibling == nil {
+		return false
+	}
+	h.cur = h.cur.NextSibling
+	return true
+}
+
+func (h *htmlNavigator) MoveToPrevious() bool {
+	if h.attr != -1 || h.cur.PrevSibling == nil {
+		return false
+	}
+	h.cur = h.cur.PrevSibling
+	return true
+}
+
+func (h *htmlNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	o, ok := other.(*htmlNavigator)
+	if !ok || o.root != h.root {
+		return false
+	}
+	h.cur, h.attr = o.cur, o.attr
+	return true
+}
+
+func (h *htmlNavigator) node() *XPathNode {
+	return &XPathNode{Name: h.nodeName(), Value: h.Value(), HTML: h.cur}
+}
+
+func (h *htmlNavigator) nodeName() string {
+	if h.attr != -1 || h.cur.Type == html.ElementNode {
+		return h.LocalName()
+	}
+	return ""
+}
+
+// xmlNavigator implements xpath.NodeNavigator on *XMLNode,
+// prefixes are reported as the ones bound by Response.XPath
+type xmlNavigator struct {
+	root, cur *XMLNode
+	attr      int
+	prefixes  map[string]string
+}
+
+func (x *xmlNavigator) NodeType() xpath.NodeType {
+	switch x.cur.Type.(type) {
+	case xml.StartElement:
+		if x.attr != -1 {
+			return xpath.AttributeNode
+		}
+		return xpath.ElementNode
+	case xml.CharData:
+		return xpath.TextNode
+	case xml.Comment, xml.ProcInst, xml.Directive:
+		return xpath.CommentNode
+	}
+	return xpath.RootNode
+}
+
+func (x *xmlNavigator) LocalName() string {
+	if x.attr != -1 {
+		return x.cur.Attr[x.attr].Name.Local
+	}
+	return x.cur.Name.Local
+}
+
+func (x *xmlNavigator) Prefix() string {
+	name, prefix := x.cur.Name, x.cur.Prefix
+	if x.attr != -1 {
+		name, prefix = x.cur.Attr[x.attr].Name, x.cur.Attr[x.attr].Prefix
+	}
+	if p, ok := x.prefixes[name.Space]; ok && name.Space != "" {
+		return p
+	}
+	return prefix
+}
+
+func (x *xmlNavigator) NamespaceURL() string {
+	if x.attr != -1 {
+		return x.cur.Attr[x.attr].Name.Space
+	}
+	return x.cur.Name.Space
+}
+
+func (x *xmlNavigator) Value() string {
+	if x.attr != -1 {
+		return x.cur.Attr[x.attr].Value
+	}
+	switch x.cur.Type.(type) {
+	case xml.Comment, xml.ProcInst, xml.Directive:
+		return x.cur.Data
+	}
+	return x.cur.Text()
+}
+
+func (x *xmlNavigator) Copy() xpath.NodeNavigator {
+	c := *x
+	return &c
+}
+
+func (x *xmlNavigator) MoveToRoot() {
+	x.cur, x.attr = x.root, -1
+}
+
+func (x *xmlNavigator) MoveToParent() bool {
+	if x.attr != -1 {
+		x.attr = -1
+		return true
+	}
+	if x.cur.Parent == nil {
+		return false
+	}
+	x.cur = x.cur.Parent
+	return true
+}
+
+func (x *xmlNavigator) MoveToNextAttribute() bool {
+	if x.attr >= len(x.cur.Attr)-1 {
+		return false
+	}
+	x.attr++
+	return true
+}
+
+func (x *xmlNavigator) MoveToChild() bool {
+	if x.attr != -1 || x.cur.FirstChild == nil {
+		return false
+	}
+	x.cur = x.cur.FirstChild
+	return true
+}
+
+func (x *xmlNavigator) MoveToFirst() bool {
+	if x.attr != -1 || x.cur.PrevSibling == nil {
+		return false
+	}
+	for x.cur.PrevSibling != nil {
+		x.cur = x.cur.PrevSibling
+	}
+	return true
+}
+
+func (x *xmlNavigator) MoveToNext() bool {
+	if x.attr != -1 || x.cur.NextSibling == nil {
+		return false
+	}
+	x.cur = x.cur.NextSibling
+	return true
+}
+
+func (x *xmlNavigator) MoveToPrevious() bool {
+	if x.attr != -1 || x.cur.PrevSibling == nil {
+		return false
+	}
+	x.cur = x.cur.PrevSibling
+	return true
+}
+
+func (x *xmlNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	o, ok := other.(*xmlNavigator)
+	if !ok || o.root != x.root {
+		return false
+	}
+	x.cur, x.attr = o.cur, o.attr
+	return true
+}
+
+func (x *xmlNavigator) node() *XPathNode {
+	n := &XPathNode{Value: x.Value(), XML: x.cur}
+	if x.attr != -1 {
+		n.Name = x.LocalName()
+	} else if _, ok := x.cur.Type.(xml.StartElement); ok {
+		n.Name = x.LocalName()
+	}
+	return n
+}
+
+// isXML reports whether the body should be parsed as XML
+func (r *Response) isXML() bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/html" {
+		return false
+	}
+	if strings.HasSuffix(mediaType, "/xml") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	return strings.HasPrefix(strings.TrimLeft(r.Text, " \t\r\n\ufeff"), "<?xml")
+}
+
+// XMLDocument parses Response.Text as a XML document,
+// it's cached like Response.HTML
+func (r *Response) XMLDocument() (*XMLNode, error) {
+	if r.xmlDoc != nil {
+		return r.xmlDoc, nil
+	}
+	root, err := ParseXML(r.Text)
+	if err != nil {
+		return nil, err
+	}
+	r.xmlDoc = root
+	return root, nil
+}
+
+// XPath evaluates the expression on the HTML or XML body, the result is
+// one of []*XPathNode, string, float64 and bool, e.g.
+//
+//	nodes, err := resp.XPath(`//ul[@class="items"]/li/a/@href`)
+//	count, err := resp.XPath(`count(//li)`)
+//	titles, err := resp.XPath(`//atom:entry/atom:title`,
+//		map[string]string{"atom": "http://www.w3.org/2005/Atom"})
+//
+// namespaces map prefixes used in the expression to URIs for XML bodies,
+// the parsed document is cached on the Response
+func (r *Response) XPath(expr string, namespaces ...map[string]string) (interface{}, error) {
+	e, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var nav interface {
+		xpath.NodeNavigator
+		node() *XPathNode
+	}
+	if r.isXML() {
+		root, err := r.XMLDocument()
+		if err != nil {
+			return nil, err
+		}
+		prefixes := make(map[string]string)
+		for _, ns := range namespaces {
+			for prefix, uri := range ns {
+				prefixes[uri] = prefix
+			}
+		}
+		nav = &xmlNavigator{root: root, cur: root, attr: -1, prefixes: prefixes}
+	} else {
+		doc, err := r.HTML()
+		if err != nil {
+			return nil, err
+		}
+		nav = &htmlNavigator{root: doc.Root, cur: doc.Root, attr: -1}
+	}
+
+	switch v := e.Evaluate(nav).(type) {
+	case *xpath.NodeIterator:
+		nodes := make([]*XPathNode, 0)
+		for v.MoveNext() {
+			if n, ok := v.Current().(interface{ node() *XPathNode }); ok {
+				nodes = append(nodes, n.node())
+			}
+		}
+		return nodes, nil
+	default:
+		return v, nil
+	}
+}